@@ -0,0 +1,112 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/gravitational/stolon/pkg/hook"
+)
+
+const (
+	hookOnStart   = "on_start"
+	hookOnStop    = "on_stop"
+	hookOnPromote = "on_promote"
+	hookOnDemote  = "on_demote"
+	hookOnResync  = "on_resync"
+)
+
+// hookCommand returns the command defined for the hook (empty if not
+// defined).
+func (p *PostgresKeeper) hookCommand(name string) string {
+	switch name {
+	case hookOnStart:
+		return p.cfg.hookOnStart
+	case hookOnStop:
+		return p.cfg.hookOnStop
+	case hookOnPromote:
+		return p.cfg.hookOnPromote
+	case hookOnDemote:
+		return p.cfg.hookOnDemote
+	case hookOnResync:
+		return p.cfg.hookOnResync
+	}
+	return ""
+}
+
+// runHook runs the command defined for the hook logging its result. The
+// roles are the postgres instance roles before and after the change (empty
+// if not applicable) and followed is the keeper followed by the instance.
+func (p *PostgresKeeper) runHook(name string, oldRole string, newRole string, followed string) {
+	command := p.hookCommand(name)
+	if command == "" {
+		return
+	}
+	env := []string{
+		fmt.Sprintf("STOLON_HOOK=%s", name),
+		fmt.Sprintf("STOLON_CLUSTER_NAME=%s", p.cfg.clusterName),
+		fmt.Sprintf("STOLON_KEEPER_ID=%s", p.id),
+		fmt.Sprintf("STOLON_OLD_ROLE=%s", oldRole),
+		fmt.Sprintf("STOLON_NEW_ROLE=%s", newRole),
+		fmt.Sprintf("STOLON_FOLLOWED_KEEPER=%s", followed),
+	}
+	log.Infof("running %s hook %q", name, command)
+	start := time.Now()
+	out, err := hook.RunCommand(command, env, nil, p.cfg.hookTimeout)
+	out = bytes.TrimSpace(out)
+	if err != nil {
+		log.Errorf("%s hook failed after %s: %v, output: %s", name, time.Since(start), err, out)
+		return
+	}
+	log.Infof("%s hook completed in %s, output: %s", name, time.Since(start), out)
+}
+
+// currentRole returns the current postgres instance role (empty if it
+// cannot be retrieved).
+func (p *PostgresKeeper) currentRole() string {
+	role, err := p.pgm.GetRole()
+	if err != nil {
+		return ""
+	}
+	return role.String()
+}
+
+// startPG starts the postgres instance and runs the on_start hook.
+func (p *PostgresKeeper) startPG(followed string) error {
+	if err := p.pgm.Start(); err != nil {
+		return err
+	}
+	p.runHook(hookOnStart, "", p.currentRole(), followed)
+	return nil
+}
+
+// stopPG stops the postgres instance and runs the on_stop hook if it was
+// started.
+func (p *PostgresKeeper) stopPG(fast bool) error {
+	started, err := p.pgm.IsStarted()
+	if err != nil {
+		started = false
+	}
+	role := p.currentRole()
+	if err := p.pgm.Stop(fast); err != nil {
+		return err
+	}
+	if started {
+		p.runHook(hookOnStop, role, "", "")
+	}
+	return nil
+}
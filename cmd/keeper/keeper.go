@@ -31,7 +31,9 @@ import (
 	"github.com/gravitational/stolon/common"
 	"github.com/gravitational/stolon/pkg/cluster"
 	"github.com/gravitational/stolon/pkg/flagutil"
+	"github.com/gravitational/stolon/pkg/hook"
 	"github.com/gravitational/stolon/pkg/kubernetes"
+	"github.com/gravitational/stolon/pkg/metrics"
 	"github.com/gravitational/stolon/pkg/postgresql"
 	pg "github.com/gravitational/stolon/pkg/postgresql"
 	"github.com/gravitational/stolon/pkg/store"
@@ -87,6 +89,19 @@ type config struct {
 	pgSSLCiphers            string
 	pgInitialSUUsername     string
 	pgInitialSUPasswordFile string
+	priority                int
+	noFailover              bool
+	noSync                  bool
+	recoveryMinApplyDelay   time.Duration
+	zone                    string
+	fenceLease              time.Duration
+	fenceAction             string
+	hookOnStart             string
+	hookOnStop              string
+	hookOnPromote           string
+	hookOnDemote            string
+	hookOnResync            string
+	hookTimeout             time.Duration
 }
 
 var cfg config
@@ -122,9 +137,29 @@ func init() {
 	cmdKeeper.PersistentFlags().StringVar(&cfg.pgSSLKeyFile, "pg-ssl-key-file", "", "postgres SSL private key")
 	cmdKeeper.PersistentFlags().StringVar(&cfg.pgSSLCAFile, "pg-ssl-ca-file", "", "postgres SSL certificate authority file")
 	cmdKeeper.PersistentFlags().StringVar(&cfg.pgSSLCiphers, "pg-ssl-ciphers", "", "postgres SSL allowed cipers list")
+	cmdKeeper.PersistentFlags().IntVar(&cfg.priority, "priority", 0, "keeper priority for master election. Keepers with an higher priority are preferred")
+	cmdKeeper.PersistentFlags().BoolVar(&cfg.noFailover, "no-failover", false, "never elect this keeper as master")
+	cmdKeeper.PersistentFlags().BoolVar(&cfg.noSync, "no-sync", false, "never use this keeper as a synchronous standby")
+	cmdKeeper.PersistentFlags().DurationVar(&cfg.recoveryMinApplyDelay, "recovery-min-apply-delay", 0, "make this keeper a delayed replica replaying the WAL with the provided delay (0 disables it). Delayed replicas are never elected as master or used as synchronous standbys")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.zone, "zone", "", "failure domain (eg. availability zone) of the keeper. Used to spread the synchronous standbys and to choose the new master")
+	cmdKeeper.PersistentFlags().DurationVar(&cfg.fenceLease, "fence-lease", 0, "fence the master postgres instance when the cluster view cannot be read from the store for longer than this duration (0 disables it)")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.fenceAction, "fence-action", fenceActionStop, "how to fence the postgres instance (stop or readonly). readonly is advisory since clients can override default_transaction_read_only")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.hookOnStart, "hook-on-start", "", "command (run with sh -c) executed after the postgres instance is started")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.hookOnStop, "hook-on-stop", "", "command (run with sh -c) executed after the postgres instance is stopped")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.hookOnPromote, "hook-on-promote", "", "command (run with sh -c) executed after the postgres instance is promoted to master")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.hookOnDemote, "hook-on-demote", "", "command (run with sh -c) executed after the postgres instance is converted from master to standby")
+	cmdKeeper.PersistentFlags().StringVar(&cfg.hookOnResync, "hook-on-resync", "", "command (run with sh -c) executed after the postgres instance is resynced from the followed instance")
+	cmdKeeper.PersistentFlags().DurationVar(&cfg.hookTimeout, "hook-timeout", hook.DefaultTimeout, "timeout of every hook command")
 	cmdKeeper.PersistentFlags().BoolVar(&cfg.debug, "debug", false, "enable debug logging")
 }
 
+const (
+	// Stop the postgres instance
+	fenceActionStop = "stop"
+	// Make the postgres instance read-only
+	fenceActionReadOnly = "readonly"
+)
+
 var defaultPGParameters = pg.Parameters{
 	"unix_socket_directories": "/tmp",
 	"wal_level":               "hot_standby",
@@ -203,7 +238,62 @@ func (p *PostgresKeeper) getOurReplConnParams() pg.ConnParams {
 	}
 }
 
-func (p *PostgresKeeper) createPGParameters(followersIDs []string) pg.Parameters {
+// getStandbyClusterPrimaryConnParams returns the connection parameters of the
+// remote primary followed by the master keeper of a standby cluster.
+func (p *PostgresKeeper) getStandbyClusterPrimaryConnParams() (pg.ConnParams, error) {
+	var cp pg.ConnParams
+	var err error
+	primary := p.clusterConfig.StandbyClusterPrimary
+	if strings.HasPrefix(primary, "postgres://") {
+		cp, err = pg.URLToConnParams(primary)
+	} else {
+		cp, err = pg.ParseConnString(primary)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse standby cluster primary connection string: %v", err)
+	}
+	if !cp.Isset("application_name") {
+		cp.Set("application_name", p.id)
+	}
+	return cp, nil
+}
+
+// getSynchronousStandbysIDs returns the synchronous standbys chosen by the
+// sentinel if we are the master.
+func (p *PostgresKeeper) getSynchronousStandbysIDs(cv *cluster.ClusterView) []string {
+	if cv.Master != p.id {
+		return nil
+	}
+	return cv.SynchronousStandbys
+}
+
+// synchronousStandbyNames returns the synchronous_standby_names value for the
+// provided standbys. The simple list syntax (supported by all postgres
+// versions) is used when waiting for the first standby, otherwise the
+// `FIRST n (...)` (postgres >= 9.6) or `ANY n (...)` (postgres >= 10) syntax.
+// Less standbys than minStandbys are rejected since they cannot provide the
+// requested durability.
+func synchronousStandbyNames(mode string, minStandbys uint, synchronousStandbysIDs []string) (string, error) {
+	if len(synchronousStandbysIDs) == 0 {
+		return "", nil
+	}
+	n := int(minStandbys)
+	if n > len(synchronousStandbysIDs) {
+		return "", fmt.Errorf("%d synchronous standbys are less than min_synchronous_standbys (%d)", len(synchronousStandbysIDs), n)
+	}
+	names := strings.Join(synchronousStandbysIDs, ",")
+	switch mode {
+	case cluster.SynchronousStandbysModeAny:
+		return fmt.Sprintf("ANY %d (%s)", n, names), nil
+	default:
+		if n <= 1 {
+			return names, nil
+		}
+		return fmt.Sprintf("FIRST %d (%s)", n, names), nil
+	}
+}
+
+func (p *PostgresKeeper) createPGParameters(synchronousStandbysIDs []string) pg.Parameters {
 	pgParameters := p.clusterConfig.PGParameters
 
 	// Merge default PGParameters
@@ -225,7 +315,15 @@ func (p *PostgresKeeper) createPGParameters(followersIDs []string) pg.Parameters
 
 	// Setup synchronous replication
 	if p.clusterConfig.SynchronousReplication {
-		pgParameters["synchronous_standby_names"] = strings.Join(followersIDs, ",")
+		names, err := synchronousStandbyNames(p.clusterConfig.SynchronousStandbysMode, p.clusterConfig.MinSynchronousStandbys, synchronousStandbysIDs)
+		if err != nil {
+			// Keep the current synchronous standbys
+			log.Errorf("cannot set synchronous standbys %v: %v", synchronousStandbysIDs, err)
+			if p.pgm != nil {
+				names = p.pgm.GetParameters()["synchronous_standby_names"]
+			}
+		}
+		pgParameters["synchronous_standby_names"] = names
 	} else {
 		pgParameters["synchronous_standby_names"] = ""
 	}
@@ -252,33 +350,44 @@ func (p *PostgresKeeper) createPGParameters(followersIDs []string) pg.Parameters
 		pgParameters["ssl_ciphers"] = p.pgSSLCiphers
 	}
 
+	if p.isFenced() && p.fenceAction == fenceActionReadOnly {
+		pgParameters["default_transaction_read_only"] = "on"
+	}
+
 	return pgParameters
 }
 
 type PostgresKeeper struct {
 	cfg *config
 
-	id                  string
-	dataDir             string
-	storeBackend        string
-	storeEndpoints      string
-	listenAddress       string
-	port                string
-	debug               bool
-	pgListenAddress     string
-	pgPort              string
-	pgBinPath           string
-	pgConfDir           string
-	pgReplUsername      string
-	pgReplPassword      string
-	pgSUUsername        string
-	pgSUPassword        string
-	pgSSLReplication    bool
-	pgSSLCertFile       string
-	pgSSLKeyFile        string
-	pgSSLCAFile         string
-	pgSSLCiphers        string
-	pgInitialSUUsername string
+	id                    string
+	dataDir               string
+	storeBackend          string
+	storeEndpoints        string
+	listenAddress         string
+	port                  string
+	debug                 bool
+	pgListenAddress       string
+	pgPort                string
+	pgBinPath             string
+	pgConfDir             string
+	pgReplUsername        string
+	pgReplPassword        string
+	pgSUUsername          string
+	pgSUPassword          string
+	pgSSLReplication      bool
+	pgSSLCertFile         string
+	pgSSLKeyFile          string
+	pgSSLCAFile           string
+	pgSSLCiphers          string
+	pgInitialSUUsername   string
+	priority              int
+	noFailover            bool
+	noSync                bool
+	recoveryMinApplyDelay time.Duration
+	zone                  string
+	fenceLease            time.Duration
+	fenceAction           string
 
 	e    *store.StoreManager
 	pgm  *postgresql.Manager
@@ -293,6 +402,11 @@ type PostgresKeeper struct {
 	pgStateMutex    sync.Mutex
 	getPGStateMutex sync.Mutex
 	lastPGState     *cluster.PostgresState
+
+	fenceMutex sync.Mutex
+	fenced     bool
+	// last time the cluster view was successfully read from the store
+	lastCVReadTime time.Time
 }
 
 func NewPostgresKeeper(id string, cfg *config, stop chan bool, end chan error) (*PostgresKeeper, error) {
@@ -337,6 +451,17 @@ func NewPostgresKeeper(id string, cfg *config, stop chan bool, end chan error) (
 		pgSSLCAFile:      cfg.pgSSLCAFile,
 		pgSSLCiphers:     cfg.pgSSLCiphers,
 
+		priority:   cfg.priority,
+		noFailover: cfg.noFailover,
+		noSync:     cfg.noSync,
+		zone:       cfg.zone,
+
+		recoveryMinApplyDelay: cfg.recoveryMinApplyDelay,
+
+		fenceLease:     cfg.fenceLease,
+		fenceAction:    cfg.fenceAction,
+		lastCVReadTime: time.Now(),
+
 		e:    e,
 		stop: stop,
 		end:  end,
@@ -366,6 +491,7 @@ func (p *PostgresKeeper) saveCVVersion(version int) error {
 	p.cvVersionMutex.Lock()
 	defer p.cvVersionMutex.Unlock()
 	p.cvVersion = version
+	cvVersionGauge.Set(float64(version))
 	return common.WriteFileAtomic(filepath.Join(p.dataDir, "cvversion"), []byte(strconv.Itoa(version)), 0600)
 }
 
@@ -388,12 +514,18 @@ func (p *PostgresKeeper) infoHandler(w http.ResponseWriter, req *http.Request) {
 	defer p.cvVersionMutex.Unlock()
 
 	keeperInfo := cluster.KeeperInfo{
-		ID:                 p.id,
-		ClusterViewVersion: p.cvVersion,
-		ListenAddress:      p.listenAddress,
-		Port:               p.port,
-		PGListenAddress:    p.pgListenAddress,
-		PGPort:             p.pgPort,
+		ID:                    p.id,
+		ClusterViewVersion:    p.cvVersion,
+		ListenAddress:         p.listenAddress,
+		Port:                  p.port,
+		PGListenAddress:       p.pgListenAddress,
+		PGPort:                p.pgPort,
+		Priority:              p.priority,
+		NoFailover:            p.noFailover,
+		NoSync:                p.noSync,
+		RecoveryMinApplyDelay: p.recoveryMinApplyDelay,
+		Zone:                  p.zone,
+		Fenced:                p.isFenced(),
 	}
 
 	if err := json.NewEncoder(w).Encode(&keeperInfo); err != nil {
@@ -417,13 +549,18 @@ func (p *PostgresKeeper) pgStateHandler(w http.ResponseWriter, req *http.Request
 func (p *PostgresKeeper) updatePGState(pctx context.Context) {
 	p.pgStateMutex.Lock()
 	defer p.pgStateMutex.Unlock()
+	if started, err := p.pgm.IsStarted(); err == nil {
+		postgresStartedGauge.Set(metrics.BoolToFloat(started))
+	}
 	pgState, err := p.GetPGState(pctx)
 	if err != nil {
 		log.Errorf("error getting pgstate: %v", err)
+		updatePGStateMetrics(nil)
 		return
 	}
 	log.Debugf("keeperpgState: %v", pgState)
 	p.lastPGState = pgState
+	updatePGStateMetrics(pgState)
 }
 
 func (p *PostgresKeeper) GetPGState(pctx context.Context) (*cluster.PostgresState, error) {
@@ -462,6 +599,19 @@ func (p *PostgresKeeper) GetPGState(pctx context.Context) (*cluster.PostgresStat
 		}
 		pgState.Role = role
 
+		if role == common.MasterRole {
+			ctx, cancel = context.WithTimeout(pctx, p.clusterConfig.RequestTimeout)
+			followers, err := pg.GetReplicationStats(ctx, p.getLocalConnParams().ConnString())
+			defer cancel()
+			// The followers replication state is only informative, don't
+			// lose the whole pgstate for it
+			if err != nil {
+				log.Errorf("error getting replication stats: %v", err)
+			} else {
+				pgState.Followers = followers
+			}
+		}
+
 		pgState.Initialized = true
 
 		// if timeline <= 1 then no timeline history file exists.
@@ -525,15 +675,16 @@ func (p *PostgresKeeper) Start() {
 
 	// TODO(sgotti) reconfigure the various configurations options
 	// (RequestTimeout) after a changed cluster config
-	followersIDs := cv.GetFollowersIDs(p.id)
-	pgParameters := p.createPGParameters(followersIDs)
+	pgParameters := p.createPGParameters(p.getSynchronousStandbysIDs(cv))
 	pgm := postgresql.NewManager(p.id, p.pgBinPath, p.dataDir, p.pgConfDir, pgParameters, p.getLocalConnParams().ConnString(), p.getOurReplConnParams().ConnString(), p.pgSUUsername, p.pgSUPassword, p.pgReplUsername, p.pgReplPassword, p.clusterConfig.RequestTimeout)
+	pgm.SetRecoveryMinApplyDelay(p.recoveryMinApplyDelay)
 	p.pgm = pgm
 
 	p.pgm.Stop(false)
 
 	http.HandleFunc("/info", p.infoHandler)
 	http.HandleFunc("/pgstate", p.pgStateHandler)
+	http.Handle("/metrics", metricsRegistry)
 	go func() {
 		endApiCh <- http.ListenAndServe(fmt.Sprintf("%s:%s", p.listenAddress, p.port), nil)
 	}()
@@ -551,13 +702,13 @@ func (p *PostgresKeeper) Start() {
 		case sig := <-exitSignals:
 			log.Infof("Caught signal: %v. Stopping stolon keeper.", sig)
 			cancel()
-			p.pgm.Stop(false)
+			p.stopPG(false)
 			close(p.end)
 			return
 		case <-p.stop:
 			log.Info("Stopping stolon keeper.")
 			cancel()
-			p.pgm.Stop(false)
+			p.stopPG(false)
 			close(p.end)
 			return
 
@@ -609,6 +760,10 @@ func (p *PostgresKeeper) Start() {
 
 func (p *PostgresKeeper) resync(followed *cluster.KeeperState, initialized, started bool) error {
 	pgm := p.pgm
+	var oldRole string
+	if initialized {
+		oldRole = p.currentRole()
+	}
 	if initialized && started {
 		if err := pgm.Stop(false); err != nil {
 			return fmt.Errorf("failed to stop pg instance: %v", err)
@@ -631,6 +786,8 @@ func (p *PostgresKeeper) resync(followed *cluster.KeeperState, initialized, star
 			if err := pgm.WriteRecoveryConf(replConnParams); err != nil {
 				return fmt.Errorf("err: %v", err)
 			}
+			resyncsCounter.Inc(resyncMethodPGRewind)
+			p.runHook(hookOnResync, oldRole, common.StandbyRole.String(), followed.ID)
 			return nil
 		}
 	}
@@ -643,10 +800,12 @@ func (p *PostgresKeeper) resync(followed *cluster.KeeperState, initialized, star
 		return fmt.Errorf("error: %v", err)
 	}
 	log.Infof("sync from followed instance %q successfully finished", followed.ID)
+	resyncsCounter.Inc(resyncMethodPGBasebackup)
 
 	if err := pgm.WriteRecoveryConf(replConnParams); err != nil {
 		return fmt.Errorf("err: %v", err)
 	}
+	p.runHook(hookOnResync, oldRole, common.StandbyRole.String(), followed.ID)
 	return nil
 }
 
@@ -682,16 +841,24 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 	cv, _, err := e.GetClusterView()
 	if err != nil {
 		log.Errorf("error retrieving cluster view: %v", err)
+		p.checkFenceLease()
 		return
 	}
 	log.Debugf(spew.Sprintf("clusterView: %#v", cv))
+	if cv != nil {
+		requiredCVVersionGauge.Set(float64(cv.Version))
+	}
+	p.fenceMutex.Lock()
+	p.lastCVReadTime = time.Now()
+	p.fenceMutex.Unlock()
 
 	if cv == nil {
 		log.Infof("no clusterview available, waiting for it to appear")
 		return
 	}
 
-	followersIDs := cv.GetFollowersIDs(p.id)
+	// Spare followers don't use a replication slot
+	followersIDs := cv.GetActiveFollowersIDs(p.id)
 
 	// Update cluster config
 	clusterConfig := cv.Config.ToConfig()
@@ -701,7 +868,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 
 	prevPGParameters := pgm.GetParameters()
 	// create postgres parameteres
-	pgParameters := p.createPGParameters(followersIDs)
+	pgParameters := p.createPGParameters(p.getSynchronousStandbysIDs(cv))
 	// update pgm postgres parameters
 	pgm.SetParameters(pgParameters)
 
@@ -725,7 +892,27 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 	}
 
 	if len(cv.KeepersRole) == 0 {
-		if initialized {
+		if p.clusterConfig.StandbyCluster {
+			// The instance must be a copy of the remote primary
+			if !initialized {
+				log.Infof("Initializing database from the standby cluster primary")
+				var primaryConnParams pg.ConnParams
+				primaryConnParams, err = p.getStandbyClusterPrimaryConnParams()
+				if err != nil {
+					log.Errorf("%v", err)
+					return
+				}
+				if err = pgm.InitStandby(primaryConnParams); err != nil {
+					log.Errorf("failed to initialize postgres instance from the standby cluster primary: %v", err)
+					return
+				}
+				if err = pgm.WriteRemoteRecoveryConf(primaryConnParams, p.clusterConfig.StandbyClusterSlotName); err != nil {
+					log.Errorf("err: %v", err)
+					return
+				}
+				initialized = true
+			}
+		} else if initialized {
 			err = pgm.CreateReplicationLagFunction()
 			if err != nil {
 				log.Errorf("failed to create replication lag function: %v", err)
@@ -767,6 +954,23 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 		log.Infof("current pg state: standby")
 	}
 
+	// Fence the instance while it's master but not the elected one. It
+	// will be converted to a standby below.
+	if needsDemotionFence(isMaster, started, cv.Master, p.id, p.fenceLease) {
+		p.fence("it isn't the elected master anymore")
+		// The fence action could have stopped the instance
+		started, err = pgm.IsStarted()
+		if err != nil {
+			log.Errorf("failed to retrieve instance status: %v", err)
+			return
+		}
+	} else {
+		p.unfence()
+	}
+	// The postgres parameters depend on the fenced state
+	pgParameters = p.createPGParameters(p.getSynchronousStandbysIDs(cv))
+	pgm.SetParameters(pgParameters)
+
 	masterID := cv.Master
 	log.Debugf("masterID: %q", masterID)
 
@@ -775,7 +979,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 		// No information about our role
 		log.Infof("our keeper requested role is not available")
 		if initialized && !started {
-			if err = pgm.Start(); err != nil {
+			if err = p.startPG(""); err != nil {
 				log.Errorf("failed to start postgres: %v", err)
 				return
 			} else {
@@ -784,7 +988,62 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 		}
 		return
 	}
-	if p.id == masterID {
+	// Spare standbys don't use a replication slot on the followed instance
+	pgm.SetUseReplSlot(!keeperRole.Spare)
+
+	if p.id == masterID && p.clusterConfig.StandbyCluster {
+		// We are the elected master of a standby cluster
+		log.Infof("our cluster requested state is standby cluster master following the remote primary")
+		if !initialized {
+			log.Errorf("database is not initialized. This shouldn't happen!")
+			return
+		}
+		var primaryConnParams pg.ConnParams
+		primaryConnParams, err = p.getStandbyClusterPrimaryConnParams()
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		var curConnParams pg.ConnParams
+		curConnParams, err = pgm.GetPrimaryConninfo()
+		if err != nil {
+			log.Errorf("err: %v", err)
+			return
+		}
+		if isMaster || !curConnParams.Equals(primaryConnParams) {
+			// A master instance must be on the same timeline branch
+			// of the remote primary to be able to follow it
+			log.Infof("following the standby cluster primary")
+			if err = pgm.WriteRemoteRecoveryConf(primaryConnParams, p.clusterConfig.StandbyClusterSlotName); err != nil {
+				log.Errorf("err: %v", err)
+				return
+			}
+			if started {
+				if err = pgm.Restart(false); err != nil {
+					log.Errorf("err: %v", err)
+					return
+				}
+				restartsCounter.Inc()
+				if isMaster {
+					p.runHook(hookOnDemote, common.MasterRole.String(), common.StandbyRole.String(), "")
+				}
+			}
+		}
+		if !started {
+			if err = p.startPG(""); err != nil {
+				log.Errorf("failed to start postgres: %v", err)
+				return
+			} else {
+				started = true
+			}
+		}
+
+		// The other keepers cascade from us
+		if err = p.updateReplSlots(followersIDs); err != nil {
+			log.Errorf("err: %v", err)
+			return
+		}
+	} else if p.id == masterID {
 		// We are the elected master
 		log.Infof("our cluster requested state is master")
 		if !initialized {
@@ -792,7 +1051,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 			return
 		}
 		if !started {
-			if err = pgm.Start(); err != nil {
+			if err = p.startPG(""); err != nil {
 				log.Errorf("failed to start postgres: %v", err)
 				return
 			} else {
@@ -806,36 +1065,14 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 				log.Errorf("err: %v", err)
 				return
 			}
+			p.runHook(hookOnPromote, common.StandbyRole.String(), common.MasterRole.String(), "")
 		} else {
 			log.Infof("already master")
 
-			var replSlots []string
-			replSlots, err = pgm.GetReplicationSlots()
-			if err != nil {
+			if err = p.updateReplSlots(followersIDs); err != nil {
 				log.Errorf("err: %v", err)
 				return
 			}
-			// Create replication slots
-			for _, slotName := range replSlots {
-				if !util.StringInSlice(followersIDs, slotName) {
-					log.Infof("dropping replication slot for keeper %q not marked as follower", slotName)
-					if err = pgm.DropReplicationSlot(slotName); err != nil {
-						log.Errorf("err: %v", err)
-					}
-				}
-			}
-
-			for _, followerID := range followersIDs {
-				if followerID == p.id {
-					continue
-				}
-				if !util.StringInSlice(replSlots, followerID) {
-					if err = pgm.CreateReplicationSlot(followerID); err != nil {
-						log.Errorf("err: %v", err)
-					}
-				}
-			}
-
 		}
 	} else if keeperRole.Follow != "" {
 		// We are a standby
@@ -859,7 +1096,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 					return
 				}
 				if !started {
-					if err = pgm.Start(); err != nil {
+					if err = p.startPG(keeperRole.Follow); err != nil {
 						log.Errorf("err: %v", err)
 						return
 					} else {
@@ -870,7 +1107,9 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 						log.Errorf("err: %v", err)
 						return
 					}
+					restartsCounter.Inc()
 				}
+				p.runHook(hookOnDemote, common.MasterRole.String(), common.StandbyRole.String(), keeperRole.Follow)
 
 				// Check timeline history
 				// We need to update our pgState to avoid dealing with
@@ -889,7 +1128,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 						log.Errorf("failed to full resync from followed instance: %v", err)
 						return
 					}
-					if err = pgm.Start(); err != nil {
+					if err = p.startPG(keeperRole.Follow); err != nil {
 						log.Errorf("err: %v", err)
 						return
 					} else {
@@ -901,7 +1140,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 					log.Errorf("failed to full resync from followed instance: %v", err)
 					return
 				}
-				if err = pgm.Start(); err != nil {
+				if err = p.startPG(keeperRole.Follow); err != nil {
 					log.Errorf("err: %v", err)
 					return
 				} else {
@@ -915,7 +1154,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 				return
 			}
 			if !started {
-				if err = pgm.Start(); err != nil {
+				if err = p.startPG(keeperRole.Follow); err != nil {
 					log.Errorf("failed to start postgres: %v", err)
 					return
 				} else {
@@ -936,9 +1175,27 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 			newConnParams := p.getReplConnParams(followed)
 			log.Debugf(spew.Sprintf("newConnParams: %v", newConnParams))
 
-			if !curConnParams.Equals(newConnParams) {
-				log.Infof("followed instance connection parameters changed. Reconfiguring...")
-				log.Infof("following %s with connection parameters %v", keeperRole.Follow, newConnParams)
+			var curApplyDelay time.Duration
+			curApplyDelay, err = pgm.GetRecoveryMinApplyDelay()
+			if err != nil {
+				log.Errorf("err: %v", err)
+				return
+			}
+
+			var curSlotName string
+			curSlotName, err = pgm.GetPrimarySlotName()
+			if err != nil {
+				log.Errorf("err: %v", err)
+				return
+			}
+			newSlotName := p.id
+			if keeperRole.Spare {
+				newSlotName = ""
+			}
+
+			if !curConnParams.Equals(newConnParams) || curApplyDelay != p.recoveryMinApplyDelay || curSlotName != newSlotName {
+				log.Infof("followed instance connection parameters, apply delay or replication slot changed. Reconfiguring...")
+				log.Infof("following %s with connection parameters %v, apply delay %s and replication slot %q", keeperRole.Follow, newConnParams, p.recoveryMinApplyDelay, newSlotName)
 				if err = pgm.WriteRecoveryConf(newConnParams); err != nil {
 					log.Errorf("err: %v", err)
 					return
@@ -947,21 +1204,15 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 					log.Errorf("err: %v", err)
 					return
 				}
+				restartsCounter.Inc()
 			}
 
-			var replSlots []string
-			replSlots, err = pgm.GetReplicationSlots()
-			if err != nil {
+			// With cascading replication a standby can be followed
+			// by other standbys
+			if err = p.updateReplSlots(followersIDs); err != nil {
 				log.Errorf("err: %v", err)
 				return
 			}
-			// Delete replication slots on standby
-			for _, slotName := range replSlots {
-				log.Infof("deleting replication slot for standby keeper %q", slotName)
-				if err = pgm.DropReplicationSlot(slotName); err != nil {
-					log.Errorf("err: %v", err)
-				}
-			}
 
 			// Check that we can sync with followed instance
 
@@ -981,7 +1232,7 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 					log.Errorf("failed to full resync from followed instance: %v", err)
 					return
 				}
-				if err = pgm.Start(); err != nil {
+				if err = p.startPG(keeperRole.Follow); err != nil {
 					log.Errorf("err: %v", err)
 					return
 				} else {
@@ -1009,6 +1260,8 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 		pgm.SetParameters(pgParameters)
 		if err := pgm.Reload(); err != nil {
 			log.Errorf("failed to reload postgres instance: %v", err)
+		} else {
+			reloadsCounter.Inc()
 		}
 	} else {
 		// for tests
@@ -1018,13 +1271,126 @@ func (p *PostgresKeeper) postgresKeeperSM(pctx context.Context) {
 		log.Errorf("err: %v", err)
 		return
 	}
+	lastSMRunGauge.Set(float64(time.Now().Unix()))
+}
+
+func (p *PostgresKeeper) isFenced() bool {
+	p.fenceMutex.Lock()
+	defer p.fenceMutex.Unlock()
+	return p.fenced
+}
+
+// needsDemotionFence reports whether a started master instance must be
+// fenced since it isn't the elected master anymore. Fencing is disabled
+// when the fence lease is 0.
+func needsDemotionFence(isMaster, started bool, electedMasterID, id string, fenceLease time.Duration) bool {
+	if fenceLease == 0 {
+		return false
+	}
+	return isMaster && started && electedMasterID != id
+}
+
+// checkFenceLease fences the postgres instance if it's a master and the
+// cluster view wasn't read from the store for longer than the fence lease,
+// since a new master could have been elected in the meantime.
+func (p *PostgresKeeper) checkFenceLease() {
+	if p.fenceLease == 0 {
+		return
+	}
+	p.fenceMutex.Lock()
+	lastCVReadTime := p.lastCVReadTime
+	p.fenceMutex.Unlock()
+	if time.Since(lastCVReadTime) < p.fenceLease {
+		return
+	}
+	role, err := p.pgm.GetRole()
+	if err != nil {
+		log.Errorf("error retrieving current pg role: %v", err)
+		return
+	}
+	if role != common.MasterRole {
+		return
+	}
+	p.fence(fmt.Sprintf("the cluster view couldn't be read for more than %s", p.fenceLease))
+}
+
+// fence stops the postgres instance or makes it read-only (depending on the
+// fence action) and marks the keeper as fenced.
+func (p *PostgresKeeper) fence(reason string) {
+	if p.isFenced() {
+		return
+	}
+	log.Warningf("fencing postgres instance since %s", reason)
+	p.fenceMutex.Lock()
+	p.fenced = true
+	p.fenceMutex.Unlock()
+
+	switch p.fenceAction {
+	case fenceActionReadOnly:
+		// This is only advisory: default_transaction_read_only is a
+		// session default that clients can override.
+		pgParameters := p.pgm.GetParameters().Copy()
+		pgParameters["default_transaction_read_only"] = "on"
+		p.pgm.SetParameters(pgParameters)
+		if err := p.pgm.Reload(); err != nil {
+			log.Errorf("failed to reload postgres instance: %v", err)
+		} else {
+			reloadsCounter.Inc()
+		}
+	default:
+		if err := p.stopPG(true); err != nil {
+			log.Errorf("failed to stop postgres instance: %v", err)
+		}
+	}
+}
+
+// unfence marks the keeper as not fenced. The postgres instance will be
+// restarted or made writable by the keeper state machine.
+func (p *PostgresKeeper) unfence() {
+	if !p.isFenced() {
+		return
+	}
+	log.Infof("unfencing postgres instance")
+	p.fenceMutex.Lock()
+	p.fenced = false
+	p.fenceMutex.Unlock()
+}
+
+// updateReplSlots creates the replication slots for our followers and drops
+// the ones of keepers not following us anymore.
+func (p *PostgresKeeper) updateReplSlots(followersIDs []string) error {
+	replSlots, err := p.pgm.GetReplicationSlots()
+	if err != nil {
+		return err
+	}
+	for _, slotName := range replSlots {
+		if !util.StringInSlice(followersIDs, slotName) {
+			log.Infof("dropping replication slot for keeper %q not marked as follower", slotName)
+			if err := p.pgm.DropReplicationSlot(slotName); err != nil {
+				log.Errorf("err: %v", err)
+			}
+		}
+	}
+
+	for _, followerID := range followersIDs {
+		if followerID == p.id {
+			continue
+		}
+		if !util.StringInSlice(replSlots, followerID) {
+			log.Infof("creating replication slot for follower keeper %q", followerID)
+			if err := p.pgm.CreateReplicationSlot(followerID); err != nil {
+				log.Errorf("err: %v", err)
+			}
+		}
+	}
+	return nil
 }
 
 func (p *PostgresKeeper) resyncAndStart(followed *cluster.KeeperState, initialized, started bool) error {
 	if err := p.resync(followed, initialized, started); err != nil {
 		return trace.Wrap(err, "failed to full resync from followed instance")
 	}
-	if err := p.pgm.Start(); err != nil {
+	if err := p.startPG(followed.ID); err != nil {
 		return trace.Wrap(err, "error starting PostgreSQL instance")
 	}
 
@@ -1113,6 +1479,15 @@ func keeper(cmd *cobra.Command, args []string) {
 	if cfg.pgSUPassword != "" && cfg.pgSUPasswordFile != "" {
 		log.Fatalf("only one of --pg-su-password or --pg-su-passwordfile must be provided")
 	}
+	if cfg.fenceLease < 0 {
+		log.Fatalf("--fence-lease must be positive")
+	}
+	if cfg.fenceAction != fenceActionStop && cfg.fenceAction != fenceActionReadOnly {
+		log.Fatalf("--fence-action must be %q or %q", fenceActionStop, fenceActionReadOnly)
+	}
+	if cfg.recoveryMinApplyDelay < 0 {
+		log.Fatalf("--recovery-min-apply-delay must be positive")
+	}
 
 	if cfg.pgSUUsername == cfg.pgReplUsername {
 		log.Warning("superuser name and replication user name are the same. Different users are suggested.")
@@ -0,0 +1,85 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+)
+
+func TestNeedsDemotionFence(t *testing.T) {
+	tests := []struct {
+		isMaster        bool
+		started         bool
+		electedMasterID string
+		fenceLease      time.Duration
+		out             bool
+	}{
+		// Old master, started, a new master was elected
+		{isMaster: true, started: true, electedMasterID: "keeper1", fenceLease: 15 * time.Second, out: true},
+		// Fencing disabled
+		{isMaster: true, started: true, electedMasterID: "keeper1", fenceLease: 0, out: false},
+		// Still the elected master
+		{isMaster: true, started: true, electedMasterID: "keeper0", fenceLease: 15 * time.Second, out: false},
+		// Already stopped (e.g. by a previous stop fence action)
+		{isMaster: true, started: false, electedMasterID: "keeper1", fenceLease: 15 * time.Second, out: false},
+		// Standby
+		{isMaster: false, started: true, electedMasterID: "keeper1", fenceLease: 15 * time.Second, out: false},
+	}
+
+	for i, tt := range tests {
+		out := needsDemotionFence(tt.isMaster, tt.started, tt.electedMasterID, "keeper0", tt.fenceLease)
+		if out != tt.out {
+			t.Errorf("#%d: wrong result: got: %t, want: %t", i, out, tt.out)
+		}
+	}
+}
+
+func TestSynchronousStandbyNames(t *testing.T) {
+	tests := []struct {
+		mode                   string
+		minStandbys            uint
+		synchronousStandbysIDs []string
+		out                    string
+		err                    bool
+	}{
+		{mode: cluster.SynchronousStandbysModeFirst, minStandbys: 1, synchronousStandbysIDs: nil, out: ""},
+		{mode: cluster.SynchronousStandbysModeFirst, minStandbys: 1, synchronousStandbysIDs: []string{"keeper1", "keeper2"}, out: "keeper1,keeper2"},
+		{mode: cluster.SynchronousStandbysModeFirst, minStandbys: 2, synchronousStandbysIDs: []string{"keeper1", "keeper2", "keeper3"}, out: "FIRST 2 (keeper1,keeper2,keeper3)"},
+		{mode: cluster.SynchronousStandbysModeAny, minStandbys: 1, synchronousStandbysIDs: []string{"keeper1", "keeper2"}, out: "ANY 1 (keeper1,keeper2)"},
+		// Less standbys than min_synchronous_standbys
+		{mode: cluster.SynchronousStandbysModeFirst, minStandbys: 2, synchronousStandbysIDs: []string{"keeper1"}, err: true},
+		{mode: cluster.SynchronousStandbysModeAny, minStandbys: 3, synchronousStandbysIDs: []string{"keeper1", "keeper2"}, err: true},
+	}
+
+	for i, tt := range tests {
+		out, err := synchronousStandbyNames(tt.mode, tt.minStandbys, tt.synchronousStandbysIDs)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if out != tt.out {
+			t.Errorf("#%d: wrong synchronous_standby_names: got: %q, want: %q", i, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/metrics"
+)
+
+const (
+	resyncMethodPGRewind     = "pg_rewind"
+	resyncMethodPGBasebackup = "pg_basebackup"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	postgresUpGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_postgres_up",
+		"Set to 1 if the postgres instance state can be retrieved",
+	)
+	postgresStartedGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_postgres_started",
+		"Set to 1 if the postgres instance is started",
+	)
+	postgresRoleGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_postgres_role",
+		"Set to 1 for the current role (master or standby) of the postgres instance",
+		"role",
+	)
+	timelineGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_timeline_id",
+		"Current timeline of the postgres instance",
+	)
+	xlogPosGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_xlog_pos",
+		"Current xlog position of the postgres instance",
+	)
+	replicationLagGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_replication_lag_seconds",
+		"Replication lag of the postgres instance (when standby) in seconds",
+	)
+	requiredCVVersionGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_required_clusterview_version",
+		"Version of the cluster view read from the store",
+	)
+	cvVersionGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_clusterview_version",
+		"Version of the cluster view the keeper converged to",
+	)
+	lastSMRunGauge = metricsRegistry.NewGauge(
+		"stolon_keeper_last_sm_run_timestamp_seconds",
+		"Unix time of the last successful keeper state machine run",
+	)
+	resyncsCounter = metricsRegistry.NewCounter(
+		"stolon_keeper_resyncs_total",
+		"Number of resyncs from the followed instance",
+		"method",
+	)
+	reloadsCounter = metricsRegistry.NewCounter(
+		"stolon_keeper_reloads_total",
+		"Number of postgres instance reloads",
+	)
+	restartsCounter = metricsRegistry.NewCounter(
+		"stolon_keeper_restarts_total",
+		"Number of postgres instance restarts",
+	)
+)
+
+// updatePGStateMetrics updates the metrics reporting the postgres instance
+// state. pgState is nil when it cannot be retrieved.
+func updatePGStateMetrics(pgState *cluster.PostgresState) {
+	postgresRoleGauge.Reset()
+	if pgState == nil {
+		postgresUpGauge.Set(0)
+		return
+	}
+	postgresUpGauge.Set(1)
+	if !pgState.Initialized {
+		return
+	}
+	postgresRoleGauge.Set(1, string(pgState.Role))
+	timelineGauge.Set(float64(pgState.TimelineID))
+	xlogPosGauge.Set(float64(pgState.XLogPos))
+	replicationLagGauge.Set(float64(pgState.ReplicationLag))
+}
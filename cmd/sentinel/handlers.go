@@ -17,14 +17,144 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gravitational/stolon/common"
 	"github.com/gravitational/stolon/pkg/cluster"
 
 	"github.com/davecgh/go-spew/spew"
+	kvstore "github.com/docker/libkv/store"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
 )
 
+const (
+	defaultSwitchoverTimeout = 60 * time.Second
+	switchoverCheckInterval  = 1 * time.Second
+)
+
+// SentinelsStatus is the response of the sentinels endpoint
+type SentinelsStatus struct {
+	LeaderID  string
+	Sentinels cluster.SentinelsInfo
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *Sentinel) getClusterData(w http.ResponseWriter) (*cluster.ClusterData, bool) {
+	cd, _, err := s.e.GetClusterData()
+	if err != nil {
+		log.Errorf("error retrieving cluster data: %v", err)
+		http.Error(w, fmt.Sprintf("error retrieving cluster data: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if cd == nil {
+		http.Error(w, "cluster data not available", http.StatusNotFound)
+		return nil, false
+	}
+	return cd, true
+}
+
+func (s *Sentinel) clusterDataHandler(w http.ResponseWriter, req *http.Request) {
+	cd, ok := s.getClusterData(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, cd)
+}
+
+func (s *Sentinel) clusterViewHandler(w http.ResponseWriter, req *http.Request) {
+	cd, ok := s.getClusterData(w)
+	if !ok {
+		return
+	}
+	if cd.ClusterView == nil {
+		http.Error(w, "cluster view not available", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, cd.ClusterView)
+}
+
+func (s *Sentinel) keepersHandler(w http.ResponseWriter, req *http.Request) {
+	cd, ok := s.getClusterData(w)
+	if !ok {
+		return
+	}
+	keepersState := cd.KeepersState
+	if keepersState == nil {
+		keepersState = cluster.KeepersState{}
+	}
+	writeJSON(w, keepersState)
+}
+
+func (s *Sentinel) sentinelsHandler(w http.ResponseWriter, req *http.Request) {
+	sentinelsInfo, err := s.e.GetSentinelsInfo()
+	if err != nil {
+		log.Errorf("error retrieving sentinels info: %v", err)
+		http.Error(w, fmt.Sprintf("error retrieving sentinels info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	leaderID, err := s.e.GetLeaderSentinelId()
+	if err != nil {
+		log.Errorf("error retrieving leader sentinel id: %v", err)
+		http.Error(w, fmt.Sprintf("error retrieving leader sentinel id: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, &SentinelsStatus{LeaderID: leaderID, Sentinels: sentinelsInfo})
+}
+
+func (s *Sentinel) proxiesHandler(w http.ResponseWriter, req *http.Request) {
+	proxiesInfo, err := s.e.GetProxiesInfo()
+	if err != nil {
+		log.Errorf("error retrieving proxies info: %v", err)
+		http.Error(w, fmt.Sprintf("error retrieving proxies info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, proxiesInfo)
+}
+
+func (s *Sentinel) getConfigHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	configName := vars["name"]
+
+	// only configID == current is currently supported
+	if configName != "current" {
+		http.Error(w, fmt.Sprintf("wrong config name %q", configName), http.StatusBadRequest)
+		return
+	}
+
+	cd, ok := s.getClusterData(w)
+	if !ok {
+		return
+	}
+	if cd.ClusterView == nil {
+		http.Error(w, "cluster view not available", http.StatusNotFound)
+		return
+	}
+	config := cd.ClusterView.Config
+	if config == nil {
+		config = &cluster.NilConfig{}
+	}
+	w.Header().Set("ETag", etag(cd.ClusterView.Version))
+	writeJSON(w, config)
+}
+
+// updateConfigHandler replaces the cluster config. The request can provide
+// the expected cluster view version in the If-Match header: if the cluster
+// view changed in the meantime the update is refused with a 412 status code.
+// With the dryrun query parameter the config is only validated. The
+// resulting config is returned with the cluster view version in the ETag
+// header.
 func (s *Sentinel) updateConfigHandler(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
@@ -35,14 +165,20 @@ func (s *Sentinel) updateConfigHandler(w http.ResponseWriter, req *http.Request)
 	if configName != "current" {
 		log.Errorf("wrong config name %q", configName)
 		http.Error(w, fmt.Sprintf("wrong config name %q", configName), http.StatusBadRequest)
-
+		return
 	}
+	dryRun := req.URL.Query().Get("dryrun") == "true"
 
-	decoder := json.NewDecoder(req.Body)
-	var config *cluster.NilConfig
-	err := decoder.Decode(&config)
+	data, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("error reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+	config, err := cluster.ParseNilConfig(data)
+	if err != nil {
+		log.Errorf("wrong config: %v", err)
+		http.Error(w, fmt.Sprintf("wrong config: %v", err), http.StatusBadRequest)
+		return
 	}
 
 	s.updateMutex.Lock()
@@ -51,35 +187,339 @@ func (s *Sentinel) updateConfigHandler(w http.ResponseWriter, req *http.Request)
 	if !s.isLeader() {
 		log.Errorf("we aren't the sentinels leader. cannot process config update request.")
 		http.Error(w, "we aren't the sentinels leader. cannot process config update request.", http.StatusBadRequest)
+		return
 	}
 
-	log.Infof(spew.Sprintf("updating config to %#v", config))
-
 	e := s.e
 
 	cd, pair, err := e.GetClusterData()
 	if err != nil {
 		log.Errorf("error retrieving cluster data: %v", err)
 		http.Error(w, fmt.Sprintf("error retrieving cluster data: %v", err), http.StatusInternalServerError)
+		return
 	}
-
 	if cd == nil {
 		log.Errorf("empty cluster data")
 		http.Error(w, "empty cluster data", http.StatusInternalServerError)
+		return
 	}
 	if cd.ClusterView == nil {
 		log.Errorf("empty cluster view")
 		http.Error(w, "empty cluster view", http.StatusInternalServerError)
+		return
 	}
 	log.Debugf(spew.Sprintf("keepersState: %#v", cd.KeepersState))
 	log.Debugf(spew.Sprintf("clusterView: %#v", cd.ClusterView))
+	cv := cd.ClusterView
 
-	newcv := cd.ClusterView.Copy()
+	if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+		if strings.Trim(ifMatch, `"`) != strconv.Itoa(cv.Version) {
+			http.Error(w, fmt.Sprintf("cluster view version is %d, expected %s", cv.Version, ifMatch), http.StatusPreconditionFailed)
+			return
+		}
+	}
+	if err := config.CheckUpdate(cv.Config, cv.Master != ""); err != nil {
+		log.Errorf("wrong config: %v", err)
+		http.Error(w, fmt.Sprintf("wrong config: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if dryRun || reflect.DeepEqual(cv.Config, config) {
+		w.Header().Set("ETag", etag(cv.Version))
+		writeJSON(w, config)
+		return
+	}
+
+	log.Infof(spew.Sprintf("updating config to %#v", config))
+
+	newcv := cv.Copy()
 	newcv.Config = config
 	newcv.Version += 1
+	newcv.ChangeTime = time.Now()
+	if _, err := e.SetClusterData(cd.KeepersState, newcv, pair); err != nil {
+		log.Errorf("error saving clusterdata: %v", err)
+		code := http.StatusInternalServerError
+		if err == kvstore.ErrKeyModified {
+			code = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("error saving clusterdata: %v", err), code)
+		return
+	}
+	s.appendEvents(cd.KeepersState, cv, cd.KeepersState, newcv)
+
+	w.Header().Set("ETag", etag(newcv.Version))
+	writeJSON(w, config)
+}
+
+// etag returns the ETag header value for the cluster view version
+func etag(version int) string {
+	return fmt.Sprintf("%q", strconv.Itoa(version))
+}
+
+func (s *Sentinel) switchoverHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	targetID := req.URL.Query().Get("to")
+	timeout := defaultSwitchoverTimeout
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			log.Errorf("wrong switchover timeout %q: %v", t, err)
+			http.Error(w, fmt.Sprintf("wrong switchover timeout %q: %v", t, err), http.StatusBadRequest)
+			return
+		}
+	}
+	deadline := time.Now().Add(timeout)
+
+	prevMasterID, newcv, code, err := s.switchover(targetID, deadline)
+	if err != nil {
+		log.Errorf("switchover failed: %v", err)
+		http.Error(w, fmt.Sprintf("switchover failed: %v", err), code)
+		return
+	}
+	log.Infof("switchover: waiting for previous master %q to become a standby", prevMasterID)
+	if err := s.waitKeeperStandby(prevMasterID, newcv.Version, deadline); err != nil {
+		log.Errorf("switchover: %v", err)
+		http.Error(w, fmt.Sprintf("new master %q elected but %v", newcv.Master, err), http.StatusGatewayTimeout)
+		return
+	}
+	log.Infof("switchover from %q to %q completed", prevMasterID, newcv.Master)
+
+	if err := json.NewEncoder(w).Encode(newcv); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// switchover elects targetID (or the best standby if empty) as the new
+// master. Before changing the master the proxies are told to close their
+// connections and the sentinel waits for the new master to replay all the
+// current master's transaction log, so no committed transaction is lost.
+// The sentinel checks keep running while waiting but don't change the
+// clusterView. It returns the previous master id, the new clusterView and,
+// on error, the http status code to report.
+func (s *Sentinel) switchover(targetID string, deadline time.Time) (string, *cluster.ClusterView, int, error) {
+	cv, newcv, keepersState, code, err := s.startSwitchover(targetID)
+	if err != nil {
+		return "", nil, code, err
+	}
+	defer func() {
+		s.updateMutex.Lock()
+		s.switchoverInProgress = false
+		s.updateMutex.Unlock()
+	}()
+	prevMasterID := cv.Master
+	targetID = newcv.Master
+
+	if err := s.waitStandbyCatchUp(keepersState[prevMasterID], keepersState[targetID], deadline); err != nil {
+		// The next sentinel check will restore the proxyconf
+		return "", nil, http.StatusGatewayTimeout, err
+	}
+
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	if !s.isLeader() {
+		return "", nil, http.StatusConflict, fmt.Errorf("we aren't the sentinels leader anymore")
+	}
+	e := s.e
+	cd, pair, err := e.GetClusterData()
+	if err != nil {
+		return "", nil, http.StatusInternalServerError, fmt.Errorf("error retrieving cluster data: %v", err)
+	}
+	if cd == nil || cd.ClusterView == nil {
+		return "", nil, http.StatusInternalServerError, fmt.Errorf("empty cluster data")
+	}
+	// The new clusterView is computed from the one before the switchover
+	if cd.ClusterView.Version != newcv.Version-1 {
+		return "", nil, http.StatusConflict, fmt.Errorf("cluster view changed during the switchover (version %d, expected %d)", cd.ClusterView.Version, newcv.Version-1)
+	}
+
+	newcv.ChangeTime = time.Now()
+	log.Debugf(spew.Sprintf("newcv: %#v", newcv))
 	if _, err := e.SetClusterData(cd.KeepersState, newcv, pair); err != nil {
+		return "", nil, http.StatusInternalServerError, fmt.Errorf("error saving clusterdata: %v", err)
+	}
+	s.appendEvents(cd.KeepersState, cv, cd.KeepersState, newcv)
+	return prevMasterID, newcv, http.StatusOK, nil
+}
+
+// startSwitchover computes the switchover clusterView and tells the proxies
+// to close their connections to the current master. It returns the current
+// clusterView, the new one and the keepers state.
+func (s *Sentinel) startSwitchover(targetID string) (*cluster.ClusterView, *cluster.ClusterView, cluster.KeepersState, int, error) {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	if !s.isLeader() {
+		return nil, nil, nil, http.StatusBadRequest, fmt.Errorf("we aren't the sentinels leader. cannot process switchover request.")
+	}
+	if s.switchoverInProgress {
+		return nil, nil, nil, http.StatusConflict, fmt.Errorf("another switchover is in progress")
+	}
+
+	e := s.e
+
+	cd, pair, err := e.GetClusterData()
+	if err != nil {
+		return nil, nil, nil, http.StatusInternalServerError, fmt.Errorf("error retrieving cluster data: %v", err)
+	}
+	if cd == nil || cd.ClusterView == nil {
+		return nil, nil, nil, http.StatusInternalServerError, fmt.Errorf("empty cluster data")
+	}
+	cv := cd.ClusterView
+	keepersState := cd.KeepersState
+	s.clusterConfig = cv.Config.ToConfig()
+	if s.clusterConfig.MaintenanceMode {
+		return nil, nil, nil, http.StatusBadRequest, fmt.Errorf("cluster is in maintenance mode")
+	}
+
+	newcv, err := s.decider().SwitchoverClusterView(cv, keepersState, targetID)
+	if err != nil {
+		return nil, nil, nil, http.StatusBadRequest, err
+	}
+	log.Infof("switchover from %q to %q requested", cv.Master, newcv.Master)
+
+	// Tell the proxies to close connections to the current master
+	if cv.ProxyConf != nil {
+		log.Infof("deleting proxyconf")
+		fencedcv := cv.Copy()
+		fencedcv.ProxyConf = nil
+		fencedcv.Version = cv.Version + 1
+		fencedcv.ChangeTime = time.Now()
+		if _, err = e.SetClusterData(keepersState, fencedcv, pair); err != nil {
+			return nil, nil, nil, http.StatusInternalServerError, fmt.Errorf("error saving clusterdata: %v", err)
+		}
+		newcv.Version = fencedcv.Version + 1
+	}
+
+	s.switchoverInProgress = true
+	return cv, newcv, keepersState, http.StatusOK, nil
+}
+
+func (s *Sentinel) removeKeeperHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	vars := mux.Vars(req)
+	id := vars["id"]
+	force := req.URL.Query().Get("force") == "true"
+
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+
+	if !s.isLeader() {
+		log.Errorf("we aren't the sentinels leader. cannot process remove keeper request.")
+		http.Error(w, "we aren't the sentinels leader. cannot process remove keeper request.", http.StatusBadRequest)
+		return
+	}
+
+	e := s.e
+
+	cd, pair, err := e.GetClusterData()
+	if err != nil {
+		log.Errorf("error retrieving cluster data: %v", err)
+		http.Error(w, fmt.Sprintf("error retrieving cluster data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if cd == nil || cd.ClusterView == nil {
+		log.Errorf("empty cluster data")
+		http.Error(w, "empty cluster data", http.StatusInternalServerError)
+		return
+	}
+	cv := cd.ClusterView
+	keepersState := cd.KeepersState
+	if _, ok := keepersState[id]; !ok {
+		if _, ok := cv.KeepersRole[id]; !ok {
+			http.Error(w, fmt.Sprintf("keeper %q doesn't exist", id), http.StatusNotFound)
+			return
+		}
+	}
+	s.clusterConfig = cv.Config.ToConfig()
+
+	// A keeper still reporting its info would be added back by the next
+	// check
+	ctx, cancel := context.WithTimeout(context.Background(), s.clusterConfig.RequestTimeout)
+	keepersDiscoveryInfo, err := s.discover(ctx)
+	cancel()
+	if err != nil {
+		log.Errorf("err: %v", err)
+		http.Error(w, fmt.Sprintf("cannot discover keepers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), s.clusterConfig.RequestTimeout)
+	keepersInfo, err := getKeepersInfo(ctx, keepersDiscoveryInfo)
+	cancel()
+	if err != nil {
+		log.Errorf("err: %v", err)
+		http.Error(w, fmt.Sprintf("cannot get keepers info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := keepersInfo[id]; ok {
+		log.Errorf("cannot remove keeper %q: it's still running", id)
+		http.Error(w, fmt.Sprintf("cannot remove keeper %q: it's still running, stop it before removing it", id), http.StatusConflict)
+		return
+	}
+
+	newcv, newKeepersState, err := s.decider().RemoveKeeper(cv, keepersState, id, force)
+	if err != nil {
+		log.Errorf("cannot remove keeper %q: %v", id, err)
+		http.Error(w, fmt.Sprintf("cannot remove keeper %q: %v", id, err), http.StatusConflict)
+		return
+	}
+	log.Infof("removing keeper %q", id)
+	log.Debugf(spew.Sprintf("newcv: %#v", newcv))
+	if _, err := e.SetClusterData(newKeepersState, newcv, pair); err != nil {
 		log.Errorf("error saving clusterdata: %v", err)
 		http.Error(w, fmt.Sprintf("error saving clusterdata: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.appendEvents(keepersState, cv, newKeepersState, newcv)
+
+	writeJSON(w, newcv)
+}
+
+// waitStandbyCatchUp waits for the standby to reach the master's current
+// xlog position.
+func (s *Sentinel) waitStandbyCatchUp(master, standby *cluster.KeeperState, deadline time.Time) error {
+	keepersInfo := cluster.KeepersInfo{}
+	for _, k := range []*cluster.KeeperState{master, standby} {
+		keepersInfo[k.ID] = &cluster.KeeperInfo{ID: k.ID, ListenAddress: k.ListenAddress, Port: k.Port}
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.clusterConfig.RequestTimeout)
+		keepersPGState := getKeepersPGState(ctx, keepersInfo)
+		cancel()
+		masterPGState := keepersPGState[master.ID]
+		standbyPGState := keepersPGState[standby.ID]
+		if masterPGState != nil && standbyPGState != nil {
+			if standbyPGState.TimelineID == masterPGState.TimelineID && standbyPGState.XLogPos >= masterPGState.XLogPos {
+				return nil
+			}
+			log.Infof("waiting for keeper %q (xlog pos %d) to catch up with master %q (xlog pos %d)", standby.ID, standbyPGState.XLogPos, master.ID, masterPGState.XLogPos)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for keeper %q to catch up with master %q", standby.ID, master.ID)
+		}
+		time.Sleep(switchoverCheckInterval)
+	}
+}
+
+// waitKeeperStandby waits for the keeper to converge to the clusterView with
+// the provided version as a standby.
+func (s *Sentinel) waitKeeperStandby(id string, cvVersion int, deadline time.Time) error {
+	for {
+		keepersState, _, err := s.e.GetKeepersState()
+		if err != nil {
+			log.Errorf("error retrieving cluster data: %v", err)
+		} else if k, ok := keepersState[id]; ok {
+			if k.ClusterViewVersion >= cvVersion && k.PGState != nil && k.PGState.Role == common.StandbyRole {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for keeper %q to become a standby", id)
+		}
+		time.Sleep(switchoverCheckInterval)
 	}
 }
 
@@ -100,6 +540,60 @@ func (s *Sentinel) NewRouter() *mux.Router {
 			"/config/{name}",
 			s.updateConfigHandler,
 		},
+		Route{
+			"Switchover",
+			"POST",
+			"/switchover",
+			s.switchoverHandler,
+		},
+		Route{
+			"RemoveKeeper",
+			"DELETE",
+			"/keepers/{id}",
+			s.removeKeeperHandler,
+		},
+		Route{
+			"GetConfig",
+			"GET",
+			"/config/{name}",
+			s.getConfigHandler,
+		},
+		Route{
+			"ClusterData",
+			"GET",
+			"/clusterdata",
+			s.clusterDataHandler,
+		},
+		Route{
+			"ClusterView",
+			"GET",
+			"/clusterview",
+			s.clusterViewHandler,
+		},
+		Route{
+			"Keepers",
+			"GET",
+			"/keepers",
+			s.keepersHandler,
+		},
+		Route{
+			"Sentinels",
+			"GET",
+			"/sentinels",
+			s.sentinelsHandler,
+		},
+		Route{
+			"Proxies",
+			"GET",
+			"/proxies",
+			s.proxiesHandler,
+		},
+		Route{
+			"Metrics",
+			"GET",
+			"/metrics",
+			metricsRegistry.ServeHTTP,
+		},
 	}
 	router := mux.NewRouter().StrictSlash(true)
 	for _, route := range routes {
@@ -0,0 +1,66 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/hook"
+)
+
+// hookEvent is the json payload sent to the hooks
+type hookEvent struct {
+	ClusterName string
+	*cluster.Event
+}
+
+// newNotifier returns the notifier for the hooks defined in cfg.
+func newNotifier(cfg *config) *hook.Notifier {
+	hooks := []hook.Hook{}
+	for _, url := range cfg.hookWebhooks {
+		hooks = append(hooks, &hook.Webhook{URL: url, Timeout: cfg.hookTimeout})
+	}
+	if cfg.hookExec != "" {
+		hooks = append(hooks, &hook.Exec{Command: cfg.hookExec, Timeout: cfg.hookTimeout})
+	}
+	return hook.NewNotifier(hooks, cfg.hookRetries, hook.DefaultRetryInterval)
+}
+
+// notifyEvent fires the hooks for the events reporting a master change, a
+// keeper becoming unhealthy or a keeper quarantined.
+func (s *Sentinel) notifyEvent(event *cluster.Event) {
+	switch event.Type {
+	case cluster.EventMasterElected:
+	case cluster.EventKeeperUnhealthy:
+	case cluster.EventKeeperQuarantined:
+	default:
+		return
+	}
+	payload, err := json.Marshal(&hookEvent{ClusterName: s.cfg.clusterName, Event: event})
+	if err != nil {
+		log.Errorf("cannot marshal hook payload: %v", err)
+		return
+	}
+	env := []string{
+		fmt.Sprintf("STOLON_CLUSTER_NAME=%s", s.cfg.clusterName),
+		fmt.Sprintf("STOLON_EVENT_TYPE=%s", event.Type),
+		fmt.Sprintf("STOLON_KEEPER_ID=%s", event.KeeperID),
+		fmt.Sprintf("STOLON_CLUSTERVIEW_VERSION=%d", event.ClusterViewVersion),
+		fmt.Sprintf("STOLON_EVENT_MESSAGE=%s", event.Message),
+	}
+	s.notifier.Notify(payload, env)
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/metrics"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	leaderGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_leader",
+		"Set to 1 if the sentinel is the sentinels leader",
+	)
+	clusterViewVersionGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_clusterview_version",
+		"Version of the current cluster view",
+	)
+	keeperHealthyGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_keeper_healthy",
+		"Set to 1 if the keeper is healthy",
+		"keeper",
+	)
+	keeperReplicationLagBytesGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_keeper_replication_lag_bytes",
+		"Replication lag of the keeper from the master in bytes",
+		"keeper",
+	)
+	keeperReplicationLagSecondsGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_keeper_replication_lag_seconds",
+		"Replication lag of the keeper from the master in seconds",
+		"keeper",
+	)
+	failoversCounter = metricsRegistry.NewCounter(
+		"stolon_sentinel_failovers_total",
+		"Number of failovers performed by the sentinel",
+	)
+	checkDurationGauge = metricsRegistry.NewGauge(
+		"stolon_sentinel_check_duration_seconds",
+		"Duration of the last cluster check",
+	)
+	storeErrorsCounter = metricsRegistry.NewCounter(
+		"stolon_sentinel_store_errors_total",
+		"Number of store errors during the cluster checks",
+	)
+)
+
+// updateClusterMetrics updates the metrics reporting the cluster view and
+// the keepers state.
+func updateClusterMetrics(cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	clusterViewVersionGauge.Set(float64(cv.Version))
+
+	keeperHealthyGauge.Reset()
+	keeperReplicationLagBytesGauge.Reset()
+	keeperReplicationLagSecondsGauge.Reset()
+
+	var masterPGState *cluster.PostgresState
+	if master, ok := keepersState[cv.Master]; ok {
+		masterPGState = master.PGState
+	}
+	for id, k := range keepersState {
+		keeperHealthyGauge.Set(metrics.BoolToFloat(k.Healthy), id)
+		if k.PGState == nil || id == cv.Master {
+			continue
+		}
+		keeperReplicationLagSecondsGauge.Set(float64(k.PGState.ReplicationLag), id)
+		if masterPGState != nil && masterPGState.XLogPos >= k.PGState.XLogPos {
+			keeperReplicationLagBytesGauge.Set(float64(masterPGState.XLogPos-k.PGState.XLogPos), id)
+		}
+	}
+}
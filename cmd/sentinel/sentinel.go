@@ -16,12 +16,10 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -30,14 +28,17 @@ import (
 
 	"github.com/gravitational/stolon/common"
 	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/decision"
+	"github.com/gravitational/stolon/pkg/discovery"
 	"github.com/gravitational/stolon/pkg/flagutil"
+	"github.com/gravitational/stolon/pkg/hook"
 	"github.com/gravitational/stolon/pkg/kubernetes"
+	"github.com/gravitational/stolon/pkg/metrics"
 	"github.com/gravitational/stolon/pkg/store"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/docker/swarm/leadership"
-	"github.com/jmoiron/jsonq"
 	"github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
 	"golang.org/x/net/context"
@@ -53,6 +54,8 @@ var cmdSentinel = &cobra.Command{
 const (
 	storeDiscovery      = "store"
 	kubernetesDiscovery = "kubernetes"
+	dnsDiscovery        = "dns"
+	staticDiscovery     = "static"
 )
 
 type config struct {
@@ -66,9 +69,16 @@ type config struct {
 	port                    string
 	keeperPort              string
 	keeperKubeLabelSelector string
+	keeperKubeFieldSelector string
+	keeperSRVRecord         string
+	keeperAddresses         []string
 	initialClusterConfig    string
 	kubernetesNamespace     string
 	discoveryType           string
+	hookWebhooks            []string
+	hookExec                string
+	hookTimeout             time.Duration
+	hookRetries             int
 	debug                   bool
 }
 
@@ -84,10 +94,17 @@ func init() {
 	cmdSentinel.PersistentFlags().StringVar(&cfg.listenAddress, "listen-address", "localhost", "sentinel listening address")
 	cmdSentinel.PersistentFlags().StringVar(&cfg.port, "port", "6431", "sentinel listening port")
 	cmdSentinel.PersistentFlags().StringVar(&cfg.keeperKubeLabelSelector, "keeper-kube-label-selector", "", "label selector for discoverying stolon-keeper(s) under kubernetes")
-	cmdSentinel.PersistentFlags().StringVar(&cfg.keeperPort, "keeper-port", "5431", "stolon-keeper(s) listening port (used by kubernetes discovery)")
+	cmdSentinel.PersistentFlags().StringVar(&cfg.keeperKubeFieldSelector, "keeper-kube-field-selector", "", "field selector for discoverying stolon-keeper(s) under kubernetes (for example spec.nodeName=node1)")
+	cmdSentinel.PersistentFlags().StringVar(&cfg.keeperPort, "keeper-port", "5431", "stolon-keeper(s) listening port (used by kubernetes discovery and by static discovery when not provided in the address)")
+	cmdSentinel.PersistentFlags().StringVar(&cfg.keeperSRVRecord, "keeper-srv-record", "", "DNS SRV record for discovering stolon-keeper(s) with dns discovery (for example _stolon-keeper._tcp.service.consul)")
+	cmdSentinel.PersistentFlags().StringSliceVar(&cfg.keeperAddresses, "keeper-addresses", nil, "a comma-delimited list of stolon-keeper(s) addresses (host[:port]) for static discovery")
 	cmdSentinel.PersistentFlags().StringVar(&cfg.initialClusterConfig, "initial-cluster-config", "", "a file providing the initial cluster config, used only at cluster initialization, ignored if cluster is already initialized")
 	cmdSentinel.PersistentFlags().StringVar(&cfg.kubernetesNamespace, "kubernetes-namespace", "default", "the kubernetes namespace stolon is deployed under")
-	cmdSentinel.PersistentFlags().StringVar(&cfg.discoveryType, "discovery-type", "", "discovery type (store or kubernetes). Default: detected")
+	cmdSentinel.PersistentFlags().StringVar(&cfg.discoveryType, "discovery-type", "", "discovery type (store, kubernetes, dns or static). Default: detected")
+	cmdSentinel.PersistentFlags().StringSliceVar(&cfg.hookWebhooks, "hook-webhook", nil, "url receiving a POST with a json payload when a new master is elected or a keeper becomes unhealthy (can be repeated)")
+	cmdSentinel.PersistentFlags().StringVar(&cfg.hookExec, "hook-exec", "", "command (run with sh -c) executed when a new master is elected or a keeper becomes unhealthy")
+	cmdSentinel.PersistentFlags().DurationVar(&cfg.hookTimeout, "hook-timeout", hook.DefaultTimeout, "timeout of every hook execution")
+	cmdSentinel.PersistentFlags().IntVar(&cfg.hookRetries, "hook-retries", hook.DefaultRetries, "number of retries of a failed hook")
 	cmdSentinel.PersistentFlags().BoolVar(&cfg.debug, "debug", false, "enable debug logging")
 }
 
@@ -115,6 +132,7 @@ func (s *Sentinel) electionLoop() {
 					}
 					s.leader = false
 				}
+				leaderGauge.Set(metrics.BoolToFloat(s.leader))
 				s.leaderMutex.Unlock()
 
 			case err := <-errCh:
@@ -214,64 +232,6 @@ func (s *Sentinel) setSentinelInfo(ttl time.Duration) error {
 	return nil
 }
 
-func (s *Sentinel) GetBestStandby(cv *cluster.ClusterView, keepersState cluster.KeepersState, master string) (string, error) {
-	var bestID string
-	masterState := keepersState[master]
-	for id, k := range keepersState {
-		log.Debugf(spew.Sprintf("id: %s, k: %#v", id, k))
-		if id == master {
-			log.Warningf("ignoring node %q since it's the current master", id)
-			continue
-		}
-		if !k.Healthy {
-			log.Warningf("ignoring node %q since it's not healthy", id)
-			continue
-		}
-		if k.ClusterViewVersion != cv.Version {
-			log.Warningf("ignoring node since its clusterView version (%d) is different that the actual one (%d)", k.ClusterViewVersion, cv.Version)
-			continue
-		}
-		if k.PGState == nil {
-			log.Warningf("ignoring node since its pg state is unknown")
-			continue
-		}
-		if masterState.PGState.SystemID != k.PGState.SystemID {
-			log.Warningf("ignoring node since it's system ID (%s) is different than the master system ID (%s)", k.PGState.SystemID, masterState.PGState.TimelineID)
-			continue
-		}
-		if masterState.PGState.TimelineID != k.PGState.TimelineID {
-			log.Warningf("ignoring node since its pg timeline (%s) is different than master timeline (%d)", keepersState[id].PGState.TimelineID, masterState.PGState.TimelineID)
-			continue
-		}
-		if k.PGState.ReplicationLag >= s.clusterConfig.MaxReplicationLag {
-			log.Warningf("ignoring node since its replication lag (%d) more than maximum possible lag (%d)", keepersState[id].PGState.ReplicationLag, s.clusterConfig.MaxReplicationLag)
-			continue
-		}
-
-		var replicationLagB uint64
-		if masterState.PGState.XLogPos > k.PGState.XLogPos {
-			replicationLagB = masterState.PGState.XLogPos - k.PGState.XLogPos
-		} else {
-			replicationLagB = k.PGState.XLogPos - masterState.PGState.XLogPos
-		}
-		if replicationLagB >= uint64(s.clusterConfig.MaxReplicationLagB) {
-			log.Warningf("ignoring node since its replication lag in bytes (%d) more than maximum possible lag (%d)", replicationLagB, s.clusterConfig.MaxReplicationLagB)
-			continue
-		}
-		if bestID == "" {
-			bestID = id
-			continue
-		}
-		if k.PGState.XLogPos > keepersState[bestID].PGState.XLogPos {
-			bestID = id
-		}
-	}
-	if bestID == "" {
-		return "", fmt.Errorf("no standbys available")
-	}
-	return bestID, nil
-}
-
 func (s *Sentinel) discover(ctx context.Context) (cluster.KeepersDiscoveryInfo, error) {
 	switch s.cfg.discoveryType {
 	case storeDiscovery:
@@ -280,14 +240,20 @@ func (s *Sentinel) discover(ctx context.Context) (cluster.KeepersDiscoveryInfo,
 	case kubernetesDiscovery:
 		log.Debugf("using kubernetes discovery")
 		ksdi := cluster.KeepersDiscoveryInfo{}
-		podsIPs, err := s.getKubernetesPodsIPs(ctx)
+		podsIPs, err := s.podWatcher.ReadyPodsIPs()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get running pods ips: %v", err)
+			return nil, fmt.Errorf("failed to get ready pods ips: %v", err)
 		}
 		for _, podIP := range podsIPs {
 			ksdi = append(ksdi, &cluster.KeeperDiscoveryInfo{ListenAddress: podIP, Port: s.cfg.keeperPort})
 		}
 		return ksdi, nil
+	case dnsDiscovery:
+		log.Debugf("using dns discovery")
+		return discovery.DNS(s.cfg.keeperSRVRecord)
+	case staticDiscovery:
+		log.Debugf("using static discovery")
+		return discovery.Static(s.cfg.keeperAddresses, s.cfg.keeperPort)
 	default:
 		return nil, fmt.Errorf("unknown discovery type")
 	}
@@ -297,90 +263,6 @@ func (s *Sentinel) discoverStore(ctx context.Context) (cluster.KeepersDiscoveryI
 	return s.e.GetKeepersDiscoveryInfo()
 }
 
-func (s *Sentinel) getKubernetesPodsIPs(ctx context.Context) ([]string, error) {
-	podsIPs := []string{}
-
-	token, err := ioutil.ReadFile("/run/secrets/kubernetes.io/serviceaccount/token")
-	if err != nil {
-		return nil, fmt.Errorf("cannot retrieve kube api token: %v", err)
-	}
-	ca, err := ioutil.ReadFile("/run/secrets/kubernetes.io/serviceaccount/ca.crt")
-	if err != nil {
-		return nil, fmt.Errorf("cannot retrieve kube ca certificate: %v", err)
-	}
-	host := os.Getenv("KUBERNETES_SERVICE_HOST")
-	port := os.Getenv("KUBERNETES_SERVICE_PORT")
-	u, err := url.Parse(fmt.Sprintf("https://%s:%s/api/v1/namespaces/%s/pods", host, port, cfg.kubernetesNamespace))
-	if err != nil {
-		return nil, err
-	}
-	q := u.Query()
-	q.Set("labelSelector", s.cfg.keeperKubeLabelSelector)
-	u.RawQuery = q.Encode()
-
-	req, err := http.NewRequest("GET", u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	roots := x509.NewCertPool()
-	if ok := roots.AppendCertsFromPEM([]byte(ca)); !ok {
-		return nil, fmt.Errorf("failed to parse kube ca certificate")
-	}
-	tlsConfig := &tls.Config{RootCAs: roots}
-	err = httpDo(ctx, req, tlsConfig, func(resp *http.Response, err error) error {
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusNotFound {
-			return nil
-		}
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("http error code: %d, error: %s", resp.StatusCode, resp.Status)
-		}
-		// Not using kubernetes apis packages since they import tons of other packages
-		var data map[string]interface{}
-		if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
-			return err
-		}
-
-		jq := jsonq.NewQuery(data)
-
-		items, err := jq.ArrayOfObjects("items")
-		if err != nil {
-			return nil
-		}
-		for _, item := range items {
-			jq := jsonq.NewQuery(item)
-			phase, err := jq.String("status", "phase")
-			if err != nil {
-				log.Errorf("cannot get pod phase: %v", err)
-				return nil
-			}
-			log.Debugf("pod phase: %s", phase)
-			if phase != "Running" {
-				continue
-			}
-			podIP, err := jq.String("status", "podIP")
-			if err != nil {
-				log.Errorf("cannot get pod IP: %v", err)
-				return nil
-			}
-			log.Debugf("pod IP: %s", podIP)
-			podsIPs = append(podsIPs, podIP)
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return podsIPs, nil
-}
-
 func getKeepersInfo(ctx context.Context, ksdi cluster.KeepersDiscoveryInfo) (cluster.KeepersInfo, error) {
 	keepersInfo := make(cluster.KeepersInfo)
 	type Response struct {
@@ -446,231 +328,6 @@ func getKeepersPGState(ctx context.Context, ki cluster.KeepersInfo) map[string]*
 	return keepersPGState
 }
 
-func (s *Sentinel) updateKeepersState(cv *cluster.ClusterView, keepersState cluster.KeepersState, keepersInfo cluster.KeepersInfo, keepersPGState map[string]*cluster.PostgresState) cluster.KeepersState {
-	// Create newKeepersState as a copy of the current keepersState
-	newKeepersState := keepersState.Copy()
-
-	// Add new keepersInfo to newKeepersState
-	for id, ki := range keepersInfo {
-		if _, ok := newKeepersState[id]; !ok {
-			if err := newKeepersState.NewFromKeeperInfo(ki); err != nil {
-				// This shouldn't happen
-				panic(err)
-			}
-		}
-	}
-
-	// Update keeperState with keepersInfo
-	for id, ki := range keepersInfo {
-		changed, err := newKeepersState[id].ChangedFromKeeperInfo(ki)
-		if err != nil {
-			// This shouldn't happen
-			panic(err)
-		}
-		if changed {
-			newKeepersState[id].UpdateFromKeeperInfo(ki)
-		}
-	}
-
-	// Mark not found keepersInfo as in error
-	for id, _ := range newKeepersState {
-		if _, ok := keepersInfo[id]; !ok {
-			newKeepersState[id].SetError()
-		} else {
-			newKeepersState[id].CleanError()
-		}
-	}
-
-	// Update PGstate
-	for id, k := range newKeepersState {
-		if kpg, ok := keepersPGState[id]; !ok {
-			newKeepersState[id].SetError()
-		} else {
-			newKeepersState[id].CleanError()
-			k.PGState = kpg
-		}
-	}
-
-	// Update Healthy state
-	for _, k := range newKeepersState {
-		k.Healthy = s.isKeeperHealthy(k)
-	}
-
-	// Delete unhealthy keepers, except master
-	for id, state := range newKeepersState {
-		if !state.Healthy && id != cv.Master {
-			delete(newKeepersState, id)
-		}
-	}
-
-	return newKeepersState
-}
-
-func (s *Sentinel) updateClusterView(cv *cluster.ClusterView, keepersState cluster.KeepersState) (*cluster.ClusterView, error) {
-	var wantedMasterID string
-	if cv.Master == "" {
-		if cv.Version != 1 {
-			return nil, fmt.Errorf("cluster view at version %d without a defined master. This shouldn't happen!", cv.Version)
-		}
-
-		log.Debugf("trying to find initial master")
-		// Check for an initial master
-		if len(keepersState) < 1 {
-			return nil, fmt.Errorf("cannot choose initial master, no keepers registered")
-		}
-		if len(keepersState) > 1 && !s.clusterConfig.InitWithMultipleKeepers {
-			return nil, fmt.Errorf("cannot choose initial master, more than 1 keeper registered")
-		}
-		for id, k := range keepersState {
-			if k.PGState == nil {
-				return nil, fmt.Errorf("cannot init cluster using keeper %q since its pg state is unknown", id)
-			}
-			if !k.PGState.Initialized {
-				return nil, fmt.Errorf("cannot init cluster using keeper %q since pg instance is not initializied", id)
-			}
-			log.Infof("initializing cluster with master: %q", id)
-			wantedMasterID = id
-			break
-		}
-	} else {
-		masterID := cv.Master
-		wantedMasterID = masterID
-
-		masterOK := true
-		master, ok := keepersState[masterID]
-		if !ok {
-			return nil, fmt.Errorf("keeper state for master %q not available. This shouldn't happen!", masterID)
-		}
-		log.Debugf(spew.Sprintf("masterState: %#v", master))
-
-		if !master.Healthy {
-			log.Infof("master is failed")
-			masterOK = false
-		}
-
-		// Check that the wanted master is in master state (i.e. check that promotion from standby to master happened)
-		if !s.isKeeperConverged(master, cv) {
-			log.Infof("keeper %s not yet master", masterID)
-			masterOK = false
-		}
-
-		if !masterOK {
-			log.Infof("trying to find a standby to replace failed master")
-			bestStandby, err := s.GetBestStandby(cv, keepersState, masterID)
-			if err != nil {
-				log.Errorf("error trying to find the best standby: %v", err)
-			} else {
-				if bestStandby != masterID {
-					log.Infof("electing new master: %q", bestStandby)
-					wantedMasterID = bestStandby
-				} else {
-					log.Infof("cannot find a good standby to replace failed master")
-				}
-			}
-		}
-	}
-
-	newCV := cv.Copy()
-	newKeepersRole := newCV.KeepersRole
-
-	// Add new keepersRole from keepersState
-	for id, _ := range keepersState {
-		if _, ok := newKeepersRole[id]; !ok {
-			if err := newKeepersRole.Add(id, ""); err != nil {
-				// This shouldn't happen
-				panic(err)
-			}
-		}
-	}
-
-	// Delete stale keepers
-	// if keeperstate map does not contain keeper id, which we get from
-	// clusterview map then delete it
-	for id := range newKeepersRole {
-		if _, ok := keepersState[id]; !ok {
-			delete(newKeepersRole, id)
-		}
-	}
-
-	// Setup master role
-	if cv.Master != wantedMasterID {
-		newCV.Master = wantedMasterID
-		newKeepersRole[wantedMasterID].Follow = ""
-	}
-
-	// Setup standbys
-	if cv.Master == wantedMasterID {
-		// wanted master is the previous one
-		masterState := keepersState[wantedMasterID]
-		// Set standbys to follow master only if it's healthy and converged to the current cv
-		if masterState.Healthy && s.isKeeperConverged(masterState, cv) {
-			for id, _ := range newKeepersRole {
-				if id == wantedMasterID {
-					continue
-				}
-				newKeepersRole[id].Follow = wantedMasterID
-			}
-		}
-	}
-
-	s.updateProxyConf(cv, newCV, keepersState)
-
-	if !newCV.Equals(cv) {
-		newCV.Version = cv.Version + 1
-		newCV.ChangeTime = time.Now()
-	}
-	return newCV, nil
-}
-
-func (s *Sentinel) updateProxyConf(prevCV *cluster.ClusterView, cv *cluster.ClusterView, keepersState cluster.KeepersState) {
-	masterID := cv.Master
-	if prevCV.Master != masterID {
-		log.Infof("deleting proxyconf")
-		// Tell proxy to close connection to old master
-		cv.ProxyConf = nil
-		return
-	}
-
-	master, _ := keepersState[masterID]
-	if s.isKeeperConverged(master, prevCV) {
-		pc := &cluster.ProxyConf{
-			Host: master.PGListenAddress,
-			Port: master.PGPort,
-		}
-		prevPC := prevCV.ProxyConf
-		update := true
-		if prevPC != nil {
-			if prevPC.Host == pc.Host && prevPC.Port == pc.Port {
-				update = false
-			}
-		}
-		if update {
-			log.Infof("updating proxyconf to %s:%s", pc.Host, pc.Port)
-			cv.ProxyConf = pc
-		}
-	}
-	return
-}
-
-func (s *Sentinel) isKeeperHealthy(keeperState *cluster.KeeperState) bool {
-	if keeperState.ErrorStartTime.IsZero() {
-		return true
-	}
-	if time.Now().After(keeperState.ErrorStartTime.Add(s.clusterConfig.KeeperFailInterval)) {
-		return false
-	}
-	return true
-}
-
-func (s *Sentinel) isKeeperConverged(keeperState *cluster.KeeperState, cv *cluster.ClusterView) bool {
-	if keeperState.ClusterViewVersion != cv.Version {
-		if time.Now().After(cv.ChangeTime.Add(s.clusterConfig.KeeperFailInterval)) {
-			return false
-		}
-	}
-	return true
-}
-
 type Sentinel struct {
 	id  string
 	cfg *config
@@ -686,9 +343,16 @@ type Sentinel struct {
 	clusterConfig           *cluster.Config
 	initialClusterNilConfig *cluster.NilConfig
 
+	notifier *hook.Notifier
+
+	// podWatcher keeps the keepers pods updated with kubernetes discovery
+	podWatcher *kubernetes.PodWatcher
+
 	updateMutex sync.Mutex
-	leader      bool
-	leaderMutex sync.Mutex
+	// switchoverInProgress is protected by updateMutex
+	switchoverInProgress bool
+	leader               bool
+	leaderMutex          sync.Mutex
 }
 
 func NewSentinel(id string, cfg *config, stop chan bool, end chan bool) (*Sentinel, error) {
@@ -718,6 +382,23 @@ func NewSentinel(id string, cfg *config, stop chan bool, end chan bool) (*Sentin
 
 	candidate := leadership.NewCandidate(kvstore, filepath.Join(storePath, common.SentinelLeaderKey), id, 15*time.Second)
 
+	var podWatcher *kubernetes.PodWatcher
+	if cfg.discoveryType == kubernetesDiscovery {
+		kubeConfig, err := kubernetes.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get kubernetes config: %v", err)
+		}
+		kubeClient, err := kubernetes.NewClient(kubeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create kubernetes client: %v", err)
+		}
+		podWatcher = kubernetes.NewPodWatcher(kubeClient, kubernetes.PodSelector{
+			Namespace:     cfg.kubernetesNamespace,
+			LabelSelector: cfg.keeperKubeLabelSelector,
+			FieldSelector: cfg.keeperKubeFieldSelector,
+		})
+	}
+
 	return &Sentinel{
 		id:                      id,
 		cfg:                     cfg,
@@ -727,8 +408,10 @@ func NewSentinel(id string, cfg *config, stop chan bool, end chan bool) (*Sentin
 		candidate:               candidate,
 		leader:                  false,
 		initialClusterNilConfig: initialClusterNilConfig,
-		stop: stop,
-		end:  end}, nil
+		notifier:                newNotifier(cfg),
+		podWatcher:              podWatcher,
+		stop:                    stop,
+		end:                     end}, nil
 }
 
 func (s *Sentinel) Start() {
@@ -745,6 +428,10 @@ func (s *Sentinel) Start() {
 
 	go s.electionLoop()
 
+	if s.podWatcher != nil {
+		go s.podWatcher.Run(ctx)
+	}
+
 	for true {
 		select {
 		case <-s.stop:
@@ -775,6 +462,11 @@ func (s *Sentinel) Start() {
 	}
 }
 
+// decider returns the decider using the current cluster config.
+func (s *Sentinel) decider() *decision.Decider {
+	return decision.NewDecider(s.clusterConfig)
+}
+
 func (s *Sentinel) isLeader() bool {
 	s.leaderMutex.Lock()
 	defer s.leaderMutex.Unlock()
@@ -786,9 +478,14 @@ func (s *Sentinel) clusterSentinelCheck(pctx context.Context) {
 	defer s.updateMutex.Unlock()
 	e := s.e
 
+	defer func(start time.Time) {
+		checkDurationGauge.Set(time.Since(start).Seconds())
+	}(time.Now())
+
 	cd, prevCDPair, err := e.GetClusterData()
 	if err != nil {
 		log.Errorf("error retrieving cluster data: %v", err)
+		storeErrorsCounter.Inc()
 		return
 	}
 
@@ -803,6 +500,7 @@ func (s *Sentinel) clusterSentinelCheck(pctx context.Context) {
 	}
 	log.Debugf(spew.Sprintf("keepersState: %#v", keepersState))
 	log.Debugf(spew.Sprintf("clusterView: %#v", cv))
+	updateClusterMetrics(cv, keepersState)
 
 	// Update cluster config
 	// This shouldn't need a lock
@@ -810,6 +508,7 @@ func (s *Sentinel) clusterSentinelCheck(pctx context.Context) {
 
 	if err = s.setSentinelInfo(2 * s.clusterConfig.SleepInterval); err != nil {
 		log.Errorf("cannot update sentinel info: %v", err)
+		storeErrorsCounter.Inc()
 		return
 	}
 
@@ -851,14 +550,33 @@ func (s *Sentinel) clusterSentinelCheck(pctx context.Context) {
 		log.Debugf(spew.Sprintf("new clusterView: %#v", newcv))
 		if _, err = e.SetClusterData(nil, newcv, nil); err != nil {
 			log.Errorf("error saving clusterdata: %v", err)
+			storeErrorsCounter.Inc()
 		}
 		return
 	}
 
-	newKeepersState := s.updateKeepersState(cv, keepersState, keepersInfo, keepersPGState)
+	newKeepersState := s.decider().UpdateKeepersState(cv, keepersState, keepersInfo, keepersPGState)
 	log.Debugf(spew.Sprintf("newKeepersState: %#v", newKeepersState))
 
-	newcv, err := s.updateClusterView(cv, newKeepersState)
+	if s.switchoverInProgress {
+		// Only update the keepers state, the switchover will change the
+		// cluster view
+		log.Infof("switchover in progress, keeping the current cluster view")
+		if _, err := e.SetClusterData(newKeepersState, cv, prevCDPair); err != nil {
+			log.Errorf("error saving clusterdata: %v", err)
+			storeErrorsCounter.Inc()
+			return
+		}
+		s.appendEvents(keepersState, cv, newKeepersState, cv)
+		updateClusterMetrics(cv, newKeepersState)
+		return
+	}
+
+	if s.clusterConfig.MaintenanceMode {
+		// The master and the keepers roles won't change
+		log.Infof("cluster in maintenance mode, automatic failover paused")
+	}
+	newcv, err := s.decider().UpdateClusterView(cv, newKeepersState)
 	if err != nil {
 		log.Errorf("failed to update clusterView: %v", err)
 		return
@@ -870,6 +588,28 @@ func (s *Sentinel) clusterSentinelCheck(pctx context.Context) {
 
 	if _, err := e.SetClusterData(newKeepersState, newcv, prevCDPair); err != nil {
 		log.Errorf("error saving clusterdata: %v", err)
+		storeErrorsCounter.Inc()
+		return
+	}
+	if cv.Master != "" && newcv.Master != cv.Master {
+		failoversCounter.Inc()
+	}
+	s.appendEvents(keepersState, cv, newKeepersState, newcv)
+	updateClusterMetrics(newcv, newKeepersState)
+}
+
+// appendEvents saves in the store the events describing the changes between
+// the previous and the new cluster data. Errors are only logged since the
+// events are informative.
+func (s *Sentinel) appendEvents(prevKeepersState cluster.KeepersState, prevCV *cluster.ClusterView, keepersState cluster.KeepersState, cv *cluster.ClusterView) {
+	events := cluster.NewEvents(prevKeepersState, prevCV, keepersState, cv)
+	for _, event := range events {
+		log.Infof("event %s: %s", event.Type, event.Message)
+		s.notifyEvent(event)
+	}
+	if err := s.e.AppendEvents(events); err != nil {
+		log.Errorf("error saving events: %v", err)
+		storeErrorsCounter.Inc()
 	}
 }
 
@@ -903,13 +643,25 @@ func sentinel(cmd *cobra.Command, args []string) {
 			cfg.discoveryType = storeDiscovery
 		}
 	}
-	if cfg.discoveryType != storeDiscovery && cfg.discoveryType != kubernetesDiscovery {
-		log.Fatalf("unknown discovery type: %s", cfg.discoveryType)
-	}
-	if cfg.discoveryType == kubernetesDiscovery {
+	switch cfg.discoveryType {
+	case storeDiscovery:
+	case kubernetesDiscovery:
 		if cfg.keeperKubeLabelSelector == "" {
 			log.Fatalf("keeper-kube-label-selector must be define under kubernetes")
 		}
+	case dnsDiscovery:
+		if cfg.keeperSRVRecord == "" {
+			log.Fatalf("keeper-srv-record must be defined with dns discovery")
+		}
+	case staticDiscovery:
+		if len(cfg.keeperAddresses) == 0 {
+			log.Fatalf("keeper-addresses must be defined with static discovery")
+		}
+		if _, err := discovery.Static(cfg.keeperAddresses, cfg.keeperPort); err != nil {
+			log.Fatalf("%v", err)
+		}
+	default:
+		log.Fatalf("unknown discovery type: %s", cfg.discoveryType)
 	}
 
 	u := uuid.NewV4()
@@ -18,9 +18,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gravitational/stolon/common"
 	"github.com/gravitational/stolon/pkg/cluster"
@@ -113,52 +119,207 @@ func (c *ClusterClient) Config() (*cluster.NilConfig, error) {
 	return cfg, nil
 }
 
+const (
+	// AnyVersion disables the cluster view version check on config updates
+	AnyVersion = -1
+
+	maxConfigUpdateRetries   = 5
+	configUpdateRetryBackoff = 500 * time.Millisecond
+)
+
+// ConfigUpdate is the result of a config patch
+type ConfigUpdate struct {
+	// Prev is the config before the update
+	Prev *cluster.NilConfig
+	// Config is the resulting config
+	Config *cluster.NilConfig
+}
+
 func (c *ClusterClient) PatchConfig(newData []byte) error {
-	currentConfig, err := c.Config()
-	if err != nil {
-		return trace.Wrap(err, "can not get config for %v", c.clusterName)
+	_, err := c.ApplyConfigPatch(newData, false)
+	return trace.Wrap(err)
+}
+
+// ApplyConfigPatch applies the patch to the current config. The update is
+// done only if the cluster view wasn't changed in the meantime, otherwise
+// the patch is applied again to the new config. With dryRun the resulting
+// config is only validated by the leader sentinel.
+func (c *ClusterClient) ApplyConfigPatch(patch []byte, dryRun bool) (*ConfigUpdate, error) {
+	for i := 0; ; i++ {
+		cv, _, err := c.GetClusterView()
+		if err != nil {
+			return nil, trace.Wrap(err, "cannot get clusterview for %v", c.clusterName)
+		}
+		if cv == nil {
+			return nil, trace.NotFound("no clusterview available for %v", c.clusterName)
+		}
+		prev := cv.Config
+		if prev == nil {
+			prev = &cluster.NilConfig{}
+		}
+		patched, err := MergeConfigPatch(prev, patch)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		config, err := c.UpdateConfig(patched, cv.Version, dryRun)
+		if trace.IsCompareFailed(err) && i < maxConfigUpdateRetries {
+			time.Sleep(configUpdateRetryBackoff)
+			continue
+		}
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return &ConfigUpdate{Prev: prev, Config: config}, nil
 	}
-	currentData, err := json.Marshal(currentConfig)
+}
+
+// MergeConfigPatch applies the provided patch to config and returns the
+// resulting config data.
+func MergeConfigPatch(config *cluster.NilConfig, patch []byte) ([]byte, error) {
+	currentData, err := json.Marshal(config)
 	if err != nil {
-		return trace.Wrap(err, "failed to marshal config")
+		return nil, trace.Wrap(err, "failed to marshal config")
 	}
-	patched, err := strategicpatch.StrategicMergePatch(currentData, newData, &cluster.NilConfig{})
+	patched, err := strategicpatch.StrategicMergePatch(currentData, patch, &cluster.NilConfig{})
 	if err != nil {
-		return trace.Wrap(err, "failed to merge patch config")
+		return nil, trace.Wrap(err, "failed to merge patch config")
 	}
-	err = c.ReplaceConfig(patched)
-	return trace.Wrap(err)
+	return patched, nil
 }
 
 func (c *ClusterClient) ReplaceConfig(data []byte) error {
-	sid, err := c.GetLeaderSentinelId()
+	_, err := c.UpdateConfig(data, AnyVersion, false)
+	return trace.Wrap(err)
+}
+
+// UpdateConfig asks the leader sentinel to replace the config with the
+// provided config data. If version isn't AnyVersion the config is replaced
+// only if the cluster view version is still the same, otherwise a compare
+// failed error is returned. It returns the resulting config.
+func (c *ClusterClient) UpdateConfig(data []byte, version int, dryRun bool) (*cluster.NilConfig, error) {
+	path := "/config/current"
+	if dryRun {
+		path += "?dryrun=true"
+	}
+	req, err := c.newSentinelRequest("PUT", path, bytes.NewReader(data))
 	if err != nil {
-		return trace.Wrap(err)
+		return nil, trace.Wrap(err)
 	}
-	sentinel, _, err := c.GetSentinelInfo(sid)
+	req.Header.Set("Content-Type", "application/json")
+	if version != AnyVersion {
+		req.Header.Set("If-Match", fmt.Sprintf("%q", strconv.Itoa(version)))
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
 	if err != nil {
-		return trace.Wrap(err)
+		return nil, trace.Wrap(err, "error setting config")
 	}
-	if sentinel == nil {
-		return trace.NotFound("leader sentinel info not available")
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return nil, trace.CompareFailed("error setting config: cluster view changed: %s", readErrorBody(res))
+	default:
+		return nil, trace.BadParameter("error setting config: leader sentinel returned non ok code: %s: %s",
+			res.Status, readErrorBody(res))
 	}
-	req, err := http.NewRequest("PUT",
-		fmt.Sprintf("http://%s:%s/config/current",
-			sentinel.ListenAddress,
-			sentinel.Port), bytes.NewReader(data))
+	var config cluster.NilConfig
+	if err := json.NewDecoder(res.Body).Decode(&config); err != nil {
+		return nil, trace.Wrap(err, "failed to decode config")
+	}
+	return &config, nil
+}
+
+// Switchover asks the leader sentinel to elect the keeper with the provided
+// id (or the best standby if empty) as the new master. It returns the
+// resulting cluster view.
+func (c *ClusterClient) Switchover(to string, timeout time.Duration) (*cluster.ClusterView, error) {
+	q := url.Values{}
+	if to != "" {
+		q.Set("to", to)
+	}
+	q.Set("timeout", timeout.String())
+	req, err := c.newSentinelRequest("POST", "/switchover?"+q.Encode(), nil)
 	if err != nil {
-		return trace.Wrap(err, "cannot create request")
+		return nil, trace.Wrap(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
-		return trace.Wrap(err, "error setting config")
+		return nil, trace.Wrap(err, "error requesting switchover")
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return trace.BadParameter("error setting config: leader sentinel returned non ok code: %s",
-			res.Status)
+		return nil, trace.BadParameter("leader sentinel returned non ok code: %s: %s",
+			res.Status, readErrorBody(res))
+	}
+	var cv cluster.ClusterView
+	if err := json.NewDecoder(res.Body).Decode(&cv); err != nil {
+		return nil, trace.Wrap(err, "failed to decode cluster view")
+	}
+	return &cv, nil
+}
+
+// RemoveKeeper asks the leader sentinel to remove the keeper with the
+// provided id from the cluster data. The current master is removed only if
+// force is true. It returns the resulting cluster view.
+func (c *ClusterClient) RemoveKeeper(id string, force bool) (*cluster.ClusterView, error) {
+	q := url.Values{}
+	if force {
+		q.Set("force", "true")
+	}
+	req, err := c.newSentinelRequest("DELETE", "/keepers/"+url.QueryEscape(id)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, trace.Wrap(err, "error removing keeper")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, trace.BadParameter("leader sentinel returned non ok code: %s: %s",
+			res.Status, readErrorBody(res))
+	}
+	var cv cluster.ClusterView
+	if err := json.NewDecoder(res.Body).Decode(&cv); err != nil {
+		return nil, trace.Wrap(err, "failed to decode cluster view")
+	}
+	return &cv, nil
+}
+
+// newSentinelRequest returns a request for the provided path on the leader
+// sentinel.
+func (c *ClusterClient) newSentinelRequest(method, path string, body io.Reader) (*http.Request, error) {
+	sid, err := c.GetLeaderSentinelId()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	sentinel, _, err := c.GetSentinelInfo(sid)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if sentinel == nil {
+		return nil, trace.NotFound("leader sentinel info not available")
+	}
+	req, err := http.NewRequest(method,
+		fmt.Sprintf("http://%s:%s%s",
+			sentinel.ListenAddress,
+			sentinel.Port, path), body)
+	if err != nil {
+		return nil, trace.Wrap(err, "cannot create request")
+	}
+	return req, nil
+}
+
+func readErrorBody(res *http.Response) string {
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ""
 	}
-	return nil
+	return strings.TrimSpace(string(data))
 }
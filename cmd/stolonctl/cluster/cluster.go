@@ -20,11 +20,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/gravitational/stolon/cmd/stolonctl/client"
 	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/decision"
+	pg "github.com/gravitational/stolon/pkg/postgresql"
+	"github.com/gravitational/stolon/pkg/util"
 	"github.com/gravitational/trace"
 )
 
@@ -100,26 +106,52 @@ func Status(client *client.Client, clusterName string, masterOnly, toJson bool)
 		fmt.Println("No keepers state available")
 	} else {
 		kssKeys := kss.SortedKeys()
-		fmt.Fprintf(tabOut, "ID\tLISTENADDRESS\tPG LISTENADDRESS\tCV VERSION\tHEALTHY\n")
+		fmt.Fprintf(tabOut, "ID\tLISTENADDRESS\tPG LISTENADDRESS\tCV VERSION\tHEALTHY\tZONE\tDELAYED\n")
 		for _, k := range kssKeys {
 			ks := kss[k]
-			fmt.Fprintf(tabOut, "%s\t%s:%s\t%s:%s\t%d\t%t\n", ks.ID, ks.ListenAddress, ks.Port, ks.PGListenAddress, ks.PGPort, ks.ClusterViewVersion, ks.Healthy)
+			delayed := "false"
+			if ks.Delayed() {
+				delayed = ks.RecoveryMinApplyDelay.String()
+			}
+			fmt.Fprintf(tabOut, "%s\t%s:%s\t%s:%s\t%d\t%t\t%s\t%s\n", ks.ID, ks.ListenAddress, ks.Port, ks.PGListenAddress, ks.PGPort, ks.ClusterViewVersion, ks.Healthy, ks.Zone, delayed)
 		}
 	}
 	tabOut.Flush()
+	for _, id := range kss.SortedKeys() {
+		if ks := kss[id]; ks.Quarantined() {
+			fmt.Printf("Keeper %s quarantined: %s\n", id, ks.QuarantineReason)
+		}
+	}
 
 	fmt.Println("Required Cluster View")
-	fmt.Printf("Version: %d", cv.Version)
 	if cv == nil {
 		fmt.Println("No clusterview available")
 	} else {
+		fmt.Printf("Version: %d\n", cv.Version)
+		cfg := cv.Config.ToConfig()
+		if cfg.MaintenanceMode {
+			fmt.Println("Maintenance mode: on (automatic failover paused)")
+		}
+		if cfg.StandbyCluster {
+			fmt.Println("Standby cluster: on (master following a remote primary)")
+		}
+		if cv.FailoverRefusedReason != "" {
+			fmt.Printf("Failover refused: %s\n", cv.FailoverRefusedReason)
+		}
 		fmt.Printf("Master: %s\n", cv.Master)
+		if len(cv.SynchronousStandbys) > 0 {
+			fmt.Printf("Synchronous standbys: %s\n", strings.Join(cv.SynchronousStandbys, ","))
+		}
 		fmt.Println("Keepers tree")
 		for _, mr := range cv.KeepersRole {
 			if mr.Follow == "" {
-				printTree(mr.ID, cv, 0, "", true)
+				printTree(mr.ID, cv, kss, 0, "", true)
 			}
 		}
+		if master, ok := kss[cv.Master]; ok && master.PGState != nil && len(master.PGState.Followers) > 0 {
+			fmt.Println("Replication (as reported by the master)")
+			printReplicationStats(tabOut, master.PGState.Followers)
+		}
 	}
 
 	fmt.Println("")
@@ -168,18 +200,35 @@ func PrintConfig(clt *client.Client, clusterName string) error {
 	return nil
 }
 
-func PatchConfig(clt *client.Client, clusterName string, patchFile string, readStdin bool) error {
+func PatchConfig(clt *client.Client, clusterName string, patchFile string, readStdin bool, dryRun bool) error {
 	data, err := readFile(patchFile, readStdin)
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	cluster, err := clt.GetCluster(clusterName)
+	clusterClt, err := clt.GetCluster(clusterName)
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	err = cluster.PatchConfig(data)
+	update, err := clusterClt.ApplyConfigPatch(data, dryRun)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	diff, err := cluster.ConfigDiff(update.Prev, update.Config)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(diff) == 0 {
+		fmt.Fprintln(os.Stdout, "no changes")
+		return nil
+	}
+	for _, l := range diff {
+		fmt.Fprintln(os.Stdout, l)
+	}
+	if dryRun {
+		fmt.Fprintln(os.Stdout, "dry run, config not changed")
+	}
 
-	return trace.Wrap(err)
+	return nil
 }
 
 func ReplaceConfig(clt *client.Client, clusterName string, replaceFile string, readStdin bool) error {
@@ -208,6 +257,304 @@ func List(clt *client.Client) error {
 	return nil
 }
 
+func Switchover(clt *client.Client, clusterName string, to string, timeout time.Duration) error {
+	cluster, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	cv, err := cluster.Switchover(to, timeout)
+	if err != nil {
+		return trace.Wrap(err, "switchover failed")
+	}
+	fmt.Fprintf(os.Stdout, "switchover completed, new master: %s\n", cv.Master)
+
+	return nil
+}
+
+func RemoveKeeper(clt *client.Client, clusterName string, id string, force bool) error {
+	cluster, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	prevMaster := ""
+	if cv, _, err := cluster.GetClusterView(); err == nil && cv != nil {
+		prevMaster = cv.Master
+	}
+	cv, err := cluster.RemoveKeeper(id, force)
+	if err != nil {
+		return trace.Wrap(err, "cannot remove keeper %q", id)
+	}
+	fmt.Fprintf(os.Stdout, "keeper %s removed\n", id)
+	if cv.Master != prevMaster {
+		fmt.Fprintf(os.Stdout, "new master: %s\n", cv.Master)
+	}
+
+	return nil
+}
+
+func Maintenance(clt *client.Client, clusterName string, enable bool) error {
+	cluster, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := json.Marshal(map[string]bool{"maintenance_mode": enable})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err = cluster.PatchConfig(data); err != nil {
+		return trace.Wrap(err)
+	}
+	if enable {
+		fmt.Fprintln(os.Stdout, "maintenance mode enabled, automatic failover paused")
+	} else {
+		fmt.Fprintln(os.Stdout, "maintenance mode disabled")
+	}
+
+	return nil
+}
+
+// Promote turns a standby cluster into a normal cluster: the master keeper
+// stops following the remote primary and is promoted.
+func Promote(clt *client.Client, clusterName string) error {
+	cluster, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	config, err := cluster.Config()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !config.ToConfig().StandbyCluster {
+		return trace.BadParameter("cluster %v isn't a standby cluster", clusterName)
+	}
+	data, err := json.Marshal(map[string]bool{"standby_cluster": false})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err = cluster.PatchConfig(data); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Fprintln(os.Stdout, "standby cluster mode disabled, the master will be promoted")
+
+	return nil
+}
+
+func Events(clt *client.Client, clusterName string, toJson bool) error {
+	cluster, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	events, err := cluster.GetEvents()
+	if err != nil {
+		return trace.Wrap(err, "cannot get cluster events")
+	}
+
+	if toJson {
+		data, err := json.MarshalIndent(events, "", "\t")
+		if err != nil {
+			return trace.Wrap(err, "can't convert to json")
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		return nil
+	}
+
+	tabOut := new(tabwriter.Writer)
+	tabOut.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintf(tabOut, "TIME\tTYPE\tKEEPER\tCV VERSION\tMESSAGE\n")
+	for _, e := range events {
+		fmt.Fprintf(tabOut, "%s\t%s\t%s\t%d\t%s\n", e.Time.Format(time.RFC3339), e.Type, e.KeeperID, e.ClusterViewVersion, e.Message)
+	}
+	tabOut.Flush()
+
+	return nil
+}
+
+// Simulate prints how the leader sentinel would change the cluster view if
+// the provided keepers were unhealthy and the provided config patch was
+// applied. It runs the sentinel decision logic on the current cluster data
+// without writing anything to the store.
+func Simulate(clt *client.Client, clusterName string, unhealthy []string, patchFile string, readStdin bool, toJson bool) error {
+	c, err := clt.GetCluster(clusterName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	cd, _, err := c.GetClusterData()
+	if err != nil {
+		return trace.Wrap(err, "cannot get cluster data")
+	}
+	if cd == nil || cd.ClusterView == nil {
+		return trace.NotFound("cluster data not available")
+	}
+	cv := cd.ClusterView
+
+	simCV := cv.Copy()
+	if patchFile != "" || readStdin {
+		data, err := readFile(patchFile, readStdin)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		prev := cv.Config
+		if prev == nil {
+			prev = &cluster.NilConfig{}
+		}
+		patched, err := client.MergeConfigPatch(prev, data)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		config, err := cluster.ParseNilConfig(patched)
+		if err != nil {
+			return trace.BadParameter("invalid config patch: %v", err)
+		}
+		if !reflect.DeepEqual(config, cv.Config) {
+			// Like the sentinel does when the config is updated
+			simCV.Config = config
+			simCV.Version = cv.Version + 1
+			simCV.ChangeTime = time.Now()
+		}
+	}
+
+	if simCV.Config.ToConfig().MaintenanceMode {
+		fmt.Fprintln(os.Stdout, "cluster in maintenance mode, automatic failover paused")
+	}
+	newCV, err := simulateClusterView(simCV, cd.KeepersState, unhealthy)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if toJson {
+		data, err := json.MarshalIndent(newCV, "", "\t")
+		if err != nil {
+			return trace.Wrap(err, "can't convert to json")
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		return nil
+	}
+	printClusterViewDiff(cv, newCV)
+
+	return nil
+}
+
+// simulateClusterView returns the cluster view the sentinel would compute if
+// the provided keepers were failing since the keeper fail interval. Like the
+// sentinel check, the keepers state is updated from the keepers info and pg
+// states (missing for the failing keepers) before updating the cluster view.
+func simulateClusterView(cv *cluster.ClusterView, keepersState cluster.KeepersState, unhealthy []string) (*cluster.ClusterView, error) {
+	failing := map[string]bool{}
+	for _, id := range unhealthy {
+		if _, ok := keepersState[id]; !ok {
+			return nil, trace.NotFound("keeper %q not found", id)
+		}
+		failing[id] = true
+	}
+	keepersInfo := cluster.KeepersInfo{}
+	keepersPGState := map[string]*cluster.PostgresState{}
+	for id, k := range keepersState {
+		// The keepers already failing keep failing
+		if failing[id] || !k.ErrorStartTime.IsZero() || k.PGState == nil {
+			continue
+		}
+		keepersInfo[id] = keeperInfo(k)
+		keepersPGState[id] = k.PGState.Copy()
+	}
+
+	cfg := cv.Config.ToConfig()
+	// The failing keepers are marked as unhealthy at their first failed
+	// check, as if the fail interval was already elapsed
+	failCfg := *cfg
+	failCfg.KeeperFailInterval = 0
+	failCfg.KeeperFailChecks = 1
+	kss := decision.NewDecider(&failCfg).UpdateKeepersState(cv, keepersState, keepersInfo, keepersPGState)
+
+	newCV, err := decision.NewDecider(cfg).UpdateClusterView(cv, kss)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to update cluster view")
+	}
+	return newCV, nil
+}
+
+// keeperInfo returns the keeper info the keeper with the provided state would
+// report.
+func keeperInfo(k *cluster.KeeperState) *cluster.KeeperInfo {
+	return &cluster.KeeperInfo{
+		ID:                    k.ID,
+		ClusterViewVersion:    k.ClusterViewVersion,
+		ListenAddress:         k.ListenAddress,
+		Port:                  k.Port,
+		PGListenAddress:       k.PGListenAddress,
+		PGPort:                k.PGPort,
+		Priority:              k.Priority,
+		NoFailover:            k.NoFailover,
+		NoSync:                k.NoSync,
+		RecoveryMinApplyDelay: k.RecoveryMinApplyDelay,
+		Zone:                  k.Zone,
+		Fenced:                k.Fenced,
+	}
+}
+
+// printClusterViewDiff prints the differences between the cluster view cv and
+// newCV.
+func printClusterViewDiff(cv *cluster.ClusterView, newCV *cluster.ClusterView) {
+	changed := false
+	printChange := func(format string, a ...interface{}) {
+		changed = true
+		fmt.Fprintf(os.Stdout, format+"\n", a...)
+	}
+
+	if cv.Version != newCV.Version {
+		printChange("version: %d -> %d", cv.Version, newCV.Version)
+	}
+	if cv.Master != newCV.Master {
+		printChange("master: %s -> %s", cv.Master, newCV.Master)
+	}
+	if !util.CompareStringSlice(cv.SynchronousStandbys, newCV.SynchronousStandbys) {
+		printChange("synchronous standbys: [%s] -> [%s]", strings.Join(cv.SynchronousStandbys, ","), strings.Join(newCV.SynchronousStandbys, ","))
+	}
+
+	ids := []string{}
+	for id := range cv.KeepersRole {
+		ids = append(ids, id)
+	}
+	for id := range newCV.KeepersRole {
+		if _, ok := cv.KeepersRole[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		kr, ok := cv.KeepersRole[id]
+		nkr, nok := newCV.KeepersRole[id]
+		switch {
+		case !ok:
+			printChange("keeper %s: added, following %q", id, nkr.Follow)
+		case !nok:
+			printChange("keeper %s: removed", id)
+		case kr.Follow != nkr.Follow:
+			printChange("keeper %s: following %q -> %q", id, kr.Follow, nkr.Follow)
+		}
+	}
+
+	if proxyConfString(cv.ProxyConf) != proxyConfString(newCV.ProxyConf) {
+		printChange("proxy conf: %s -> %s", proxyConfString(cv.ProxyConf), proxyConfString(newCV.ProxyConf))
+	}
+	if !reflect.DeepEqual(cv.Config, newCV.Config) {
+		printChange("config: changed")
+	}
+	if newCV.FailoverRefusedReason != "" {
+		printChange("failover refused: %s", newCV.FailoverRefusedReason)
+	}
+
+	if !changed {
+		fmt.Fprintln(os.Stdout, "no changes")
+	}
+}
+
+func proxyConfString(pc *cluster.ProxyConf) string {
+	if pc == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s:%s", pc.Host, pc.Port)
+}
+
 func readFile(fileName string, readStdin bool) ([]byte, error) {
 	if (readStdin && fileName != "") || (!readStdin && fileName == "") {
 		return nil, trace.BadParameter("need either file to read from or readStdin option")
@@ -229,7 +576,21 @@ func readFile(fileName string, readStdin bool) ([]byte, error) {
 	return config, trace.Wrap(err)
 }
 
-func printTree(id string, cv *cluster.ClusterView, level int, prefix string, tail bool) {
+func printReplicationStats(tabOut *tabwriter.Writer, followers map[string]*cluster.ReplicationStat) {
+	ids := []string{}
+	for id := range followers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Fprintf(tabOut, "ID\tSTATE\tSENT\tWRITE\tFLUSH\tREPLAY\tREPLAY LAG (BYTES)\n")
+	for _, id := range ids {
+		rs := followers[id]
+		fmt.Fprintf(tabOut, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", id, rs.State, pg.IntToPGLSN(rs.SentLocation), pg.IntToPGLSN(rs.WriteLocation), pg.IntToPGLSN(rs.FlushLocation), pg.IntToPGLSN(rs.ReplayLocation), rs.ReplayLag)
+	}
+	tabOut.Flush()
+}
+
+func printTree(id string, cv *cluster.ClusterView, kss cluster.KeepersState, level int, prefix string, tail bool) {
 	out := prefix
 	if level > 0 {
 		if tail {
@@ -241,6 +602,12 @@ func printTree(id string, cv *cluster.ClusterView, level int, prefix string, tai
 	out += id
 	if id == cv.Master {
 		out += " (master)"
+	} else if ks, ok := kss[id]; ok && ks.Quarantined() {
+		out += " (quarantined)"
+	} else if ks, ok := kss[id]; ok && ks.Delayed() {
+		out += fmt.Sprintf(" (delayed %s)", ks.RecoveryMinApplyDelay)
+	} else if kr, ok := cv.KeepersRole[id]; ok && kr.Spare {
+		out += " (spare)"
 	}
 	fmt.Println(out)
 	followersIDs := cv.GetFollowersIDs(id)
@@ -253,15 +620,15 @@ func printTree(id string, cv *cluster.ClusterView, level int, prefix string, tai
 		linespace := "│ "
 		if i < c-1 {
 			if tail {
-				printTree(f, cv, level+1, prefix+emptyspace, false)
+				printTree(f, cv, kss, level+1, prefix+emptyspace, false)
 			} else {
-				printTree(f, cv, level+1, prefix+linespace, false)
+				printTree(f, cv, kss, level+1, prefix+linespace, false)
 			}
 		} else {
 			if tail {
-				printTree(f, cv, level+1, prefix+emptyspace, true)
+				printTree(f, cv, kss, level+1, prefix+emptyspace, true)
 			} else {
-				printTree(f, cv, level+1, prefix+linespace, true)
+				printTree(f, cv, kss, level+1, prefix+linespace, true)
 			}
 		}
 	}
@@ -0,0 +1,134 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+)
+
+func TestSimulateClusterView(t *testing.T) {
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+			"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+		},
+		ProxyConf:  &cluster.ProxyConf{Host: "01", Port: "01"},
+		Config:     &cluster.NilConfig{},
+		ChangeTime: time.Now().Add(-time.Hour),
+	}
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{
+			ID:                 "01",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 100},
+		},
+		"02": &cluster.KeeperState{
+			ID:                 "02",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 90},
+		},
+		"03": &cluster.KeeperState{
+			ID:                 "03",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 95},
+		},
+	}
+
+	tests := []struct {
+		unhealthy   []string
+		maintenance bool
+		master      string
+		keepersRole []string
+		err         bool
+	}{
+		// Nothing changes
+		{
+			master:      "01",
+			keepersRole: []string{"01", "02", "03"},
+		},
+		// Unhealthy standby removed
+		{
+			unhealthy:   []string{"02"},
+			master:      "01",
+			keepersRole: []string{"01", "03"},
+		},
+		// All the unhealthy standbys removed
+		{
+			unhealthy:   []string{"02", "03"},
+			master:      "01",
+			keepersRole: []string{"01"},
+		},
+		// Unhealthy master replaced
+		{
+			unhealthy:   []string{"01"},
+			master:      "03",
+			keepersRole: []string{"01", "02", "03"},
+		},
+		// Unhealthy master not replaced in maintenance mode
+		{
+			unhealthy:   []string{"01"},
+			maintenance: true,
+			master:      "01",
+			keepersRole: []string{"01", "02", "03"},
+		},
+		// Unknown keeper
+		{
+			unhealthy: []string{"04"},
+			err:       true,
+		},
+	}
+
+	for i, tt := range tests {
+		cv := cv.Copy()
+		if tt.maintenance {
+			cv.Config = &cluster.NilConfig{MaintenanceMode: cluster.BoolP(true)}
+		}
+		newCV, err := simulateClusterView(cv, keepersState, tt.unhealthy)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if newCV.Master != tt.master {
+			t.Errorf("#%d: wrong master: got: %q, want: %q", i, newCV.Master, tt.master)
+		}
+		if len(newCV.KeepersRole) != len(tt.keepersRole) {
+			t.Errorf("#%d: wrong keepers role: got: %v, want: %v", i, newCV.KeepersRole, tt.keepersRole)
+		}
+		for _, id := range tt.keepersRole {
+			if _, ok := newCV.KeepersRole[id]; !ok {
+				t.Errorf("#%d: keeper %q not in keepers role", i, id)
+			}
+		}
+		// The provided keepers state must not be changed
+		if !keepersState["01"].Healthy || !keepersState["02"].Healthy {
+			t.Errorf("#%d: keepers state changed", i)
+		}
+	}
+}
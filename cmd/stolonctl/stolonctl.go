@@ -85,6 +85,7 @@ func (app *application) run() error {
 	cmdClusterPatch := cmdCluster.Command("patch", "patch configuration for cluster")
 	cmdClusterPatchName := cmdClusterPatch.Arg("cluster-name", "cluster name").Required().String()
 	cmdClusterPatchFile := cmdClusterPatch.Flag("file", "patch configuration for cluster").Short('f').String()
+	cmdClusterPatchDryRun := cmdClusterPatch.Flag("dry-run", "only validate the patch and print the changes, without applying them").Default("false").Bool()
 	// replace config
 	cmdClusterReplace := cmdCluster.Command("replace", "replace configuration for cluster")
 	cmdClusterReplaceName := cmdClusterReplace.Arg("cluster-name", "cluster name").Required().String()
@@ -96,6 +97,33 @@ func (app *application) run() error {
 	cmdClusterStatusOutputJson := cmdClusterStatus.Flag("json", "format output to json").Default("false").Bool()
 	// list clusters
 	cmdClusterList := cmdCluster.Command("list", "list clusters")
+	// switchover
+	cmdClusterSwitchover := cmdCluster.Command("switchover", "elect a new master while the current one is healthy")
+	cmdClusterSwitchoverName := cmdClusterSwitchover.Arg("cluster-name", "cluster name").Required().String()
+	cmdClusterSwitchoverTo := cmdClusterSwitchover.Flag("to", "id of the keeper to elect as new master. If empty the best standby is chosen").String()
+	cmdClusterSwitchoverTimeout := cmdClusterSwitchover.Flag("timeout", "time to wait for the switchover to complete").Default("1m").Duration()
+	// remove keeper
+	cmdClusterRemoveKeeper := cmdCluster.Command("remove-keeper", "remove a decommissioned keeper from the cluster data")
+	cmdClusterRemoveKeeperName := cmdClusterRemoveKeeper.Arg("cluster-name", "cluster name").Required().String()
+	cmdClusterRemoveKeeperID := cmdClusterRemoveKeeper.Arg("keeper-id", "id of the keeper to remove").Required().String()
+	cmdClusterRemoveKeeperForce := cmdClusterRemoveKeeper.Flag("force", "remove the keeper also if it's the current master, electing the best standby as the new master").Default("false").Bool()
+	// maintenance mode
+	cmdClusterMaintenance := cmdCluster.Command("maintenance", "enable or disable maintenance mode (automatic failover paused)")
+	cmdClusterMaintenanceName := cmdClusterMaintenance.Arg("cluster-name", "cluster name").Required().String()
+	cmdClusterMaintenanceMode := cmdClusterMaintenance.Arg("mode", "on or off").Required().Enum("on", "off")
+	// promote
+	cmdClusterPromote := cmdCluster.Command("promote", "promote a standby cluster to a normal cluster")
+	cmdClusterPromoteName := cmdClusterPromote.Arg("cluster-name", "cluster name").Required().String()
+	// events
+	cmdClusterEvents := cmdCluster.Command("events", "print the cluster events history")
+	cmdClusterEventsName := cmdClusterEvents.Arg("cluster-name", "cluster name").Required().String()
+	cmdClusterEventsOutputJson := cmdClusterEvents.Flag("json", "format output to json").Default("false").Bool()
+	// simulate
+	cmdClusterSimulate := cmdCluster.Command("simulate", "print how the sentinel would change the cluster view, without applying the changes")
+	cmdClusterSimulateName := cmdClusterSimulate.Arg("cluster-name", "cluster name").Required().String()
+	cmdClusterSimulateUnhealthy := cmdClusterSimulate.Flag("unhealthy", "id of a keeper to consider unhealthy (can be repeated)").Strings()
+	cmdClusterSimulateFile := cmdClusterSimulate.Flag("file", "config patch to apply").Short('f').String()
+	cmdClusterSimulateOutputJson := cmdClusterSimulate.Flag("json", "print the resulting cluster view in json format").Default("false").Bool()
 
 	// database commands
 	cmdDatabase := app.Command("db", "database operations")
@@ -154,13 +182,25 @@ func (app *application) run() error {
 	case cmdClusterConfig.FullCommand():
 		return cluster.PrintConfig(clt, *cmdClusterConfigName)
 	case cmdClusterPatch.FullCommand():
-		return cluster.PatchConfig(clt, *cmdClusterPatchName, *cmdClusterPatchFile, os.Args[len(os.Args)-1] == "-")
+		return cluster.PatchConfig(clt, *cmdClusterPatchName, *cmdClusterPatchFile, os.Args[len(os.Args)-1] == "-", *cmdClusterPatchDryRun)
 	case cmdClusterReplace.FullCommand():
 		return cluster.ReplaceConfig(clt, *cmdClusterReplaceName, *cmdClusterReplaceFile, os.Args[len(os.Args)-1] == "-")
 	case cmdClusterStatus.FullCommand():
 		return cluster.Status(clt, *cmdClusterStatusName, *cmdClusterStatusMasterOnly, *cmdClusterStatusOutputJson)
 	case cmdClusterList.FullCommand():
 		return cluster.List(clt)
+	case cmdClusterSwitchover.FullCommand():
+		return cluster.Switchover(clt, *cmdClusterSwitchoverName, *cmdClusterSwitchoverTo, *cmdClusterSwitchoverTimeout)
+	case cmdClusterRemoveKeeper.FullCommand():
+		return cluster.RemoveKeeper(clt, *cmdClusterRemoveKeeperName, *cmdClusterRemoveKeeperID, *cmdClusterRemoveKeeperForce)
+	case cmdClusterMaintenance.FullCommand():
+		return cluster.Maintenance(clt, *cmdClusterMaintenanceName, *cmdClusterMaintenanceMode == "on")
+	case cmdClusterPromote.FullCommand():
+		return cluster.Promote(clt, *cmdClusterPromoteName)
+	case cmdClusterEvents.FullCommand():
+		return cluster.Events(clt, *cmdClusterEventsName, *cmdClusterEventsOutputJson)
+	case cmdClusterSimulate.FullCommand():
+		return cluster.Simulate(clt, *cmdClusterSimulateName, *cmdClusterSimulateUnhealthy, *cmdClusterSimulateFile, os.Args[len(os.Args)-1] == "-", *cmdClusterSimulateOutputJson)
 	}
 
 	return nil
@@ -19,6 +19,8 @@ import (
 	"reflect"
 	"sort"
 	"time"
+
+	"github.com/gravitational/stolon/pkg/util"
 )
 
 type KeepersState map[string]*KeeperState
@@ -46,27 +48,59 @@ func (kss KeepersState) NewFromKeeperInfo(ki *KeeperInfo) error {
 		return fmt.Errorf("keeperState with id %q already exists", id)
 	}
 	kss[id] = &KeeperState{
-		ErrorStartTime:     time.Time{},
-		ID:                 ki.ID,
-		ClusterViewVersion: ki.ClusterViewVersion,
-		ListenAddress:      ki.ListenAddress,
-		Port:               ki.Port,
-		PGListenAddress:    ki.PGListenAddress,
-		PGPort:             ki.PGPort,
+		ErrorStartTime:        time.Time{},
+		ID:                    ki.ID,
+		ClusterViewVersion:    ki.ClusterViewVersion,
+		ListenAddress:         ki.ListenAddress,
+		Port:                  ki.Port,
+		PGListenAddress:       ki.PGListenAddress,
+		PGPort:                ki.PGPort,
+		Priority:              ki.Priority,
+		NoFailover:            ki.NoFailover,
+		NoSync:                ki.NoSync,
+		RecoveryMinApplyDelay: ki.RecoveryMinApplyDelay,
+		Zone:                  ki.Zone,
+		Fenced:                ki.Fenced,
 	}
 	return nil
 }
 
 type KeeperState struct {
-	ID                 string
-	ErrorStartTime     time.Time
+	ID             string
+	ErrorStartTime time.Time
+	// Number of consecutive failed checks
+	FailedChecks int
+	// Start time of the current sequence of successful checks
+	RecoveryStartTime  time.Time
 	Healthy            bool
 	ClusterViewVersion int
 	ListenAddress      string
 	Port               string
 	PGListenAddress    string
 	PGPort             string
-	PGState            *PostgresState
+	Priority           int
+	NoFailover         bool
+	NoSync             bool
+	// RecoveryMinApplyDelay is the WAL apply delay of a delayed replica
+	RecoveryMinApplyDelay time.Duration
+	Zone                  string
+	Fenced                bool
+	PGState               *PostgresState
+	// Why the keeper is quarantined (empty if not quarantined)
+	QuarantineReason string
+}
+
+// Quarantined reports whether the keeper is quarantined since its instance
+// belongs to a different database than the master one. Quarantined keepers
+// are never elected as master or used as synchronous standbys.
+func (ks *KeeperState) Quarantined() bool {
+	return ks.QuarantineReason != ""
+}
+
+// Delayed reports whether the keeper is a delayed replica. Delayed replicas
+// are never elected as master or used as synchronous standbys.
+func (ks *KeeperState) Delayed() bool {
+	return ks.RecoveryMinApplyDelay > 0
 }
 
 func (ks *KeeperState) String() string {
@@ -102,7 +136,13 @@ func (ks *KeeperState) ChangedFromKeeperInfo(ki *KeeperInfo) (bool, error) {
 		ks.ListenAddress != ki.ListenAddress ||
 		ks.Port != ki.Port ||
 		ks.PGListenAddress != ki.PGListenAddress ||
-		ks.PGPort != ki.PGPort {
+		ks.PGPort != ki.PGPort ||
+		ks.Priority != ki.Priority ||
+		ks.NoFailover != ki.NoFailover ||
+		ks.NoSync != ki.NoSync ||
+		ks.RecoveryMinApplyDelay != ki.RecoveryMinApplyDelay ||
+		ks.Zone != ki.Zone ||
+		ks.Fenced != ki.Fenced {
 		return true, nil
 	}
 	return false, nil
@@ -117,18 +157,67 @@ func (ks *KeeperState) UpdateFromKeeperInfo(ki *KeeperInfo) error {
 	ks.Port = ki.Port
 	ks.PGListenAddress = ki.PGListenAddress
 	ks.PGPort = ki.PGPort
+	ks.Priority = ki.Priority
+	ks.NoFailover = ki.NoFailover
+	ks.NoSync = ki.NoSync
+	ks.RecoveryMinApplyDelay = ki.RecoveryMinApplyDelay
+	ks.Zone = ki.Zone
+	ks.Fenced = ki.Fenced
 
 	return nil
 }
 
+// SpreadByZone returns the provided keepers ids ordered to spread them across
+// zones: every zone is taken in turn, starting with the zones different than
+// lastZone (usually the master's zone). The relative order of keepers in the
+// same zone is kept.
+func (kss KeepersState) SpreadByZone(ids []string, lastZone string) []string {
+	zonesIDs := map[string][]string{}
+	zones := []string{}
+	for _, id := range ids {
+		var zone string
+		if k, ok := kss[id]; ok {
+			zone = k.Zone
+		}
+		if _, ok := zonesIDs[zone]; !ok && zone != lastZone {
+			zones = append(zones, zone)
+		}
+		zonesIDs[zone] = append(zonesIDs[zone], id)
+	}
+	sort.Strings(zones)
+	if _, ok := zonesIDs[lastZone]; ok {
+		zones = append(zones, lastZone)
+	}
+
+	spreadIDs := []string{}
+	for len(spreadIDs) < len(ids) {
+		for _, zone := range zones {
+			if len(zonesIDs[zone]) == 0 {
+				continue
+			}
+			spreadIDs = append(spreadIDs, zonesIDs[zone][0])
+			zonesIDs[zone] = zonesIDs[zone][1:]
+		}
+	}
+	return spreadIDs
+}
+
+// SetError records a failed check of the keeper
 func (ks *KeeperState) SetError() {
 	if ks.ErrorStartTime.IsZero() {
 		ks.ErrorStartTime = time.Now()
 	}
+	ks.FailedChecks++
+	ks.RecoveryStartTime = time.Time{}
 }
 
+// CleanError records a successful check of the keeper
 func (ks *KeeperState) CleanError() {
+	if !ks.ErrorStartTime.IsZero() || ks.RecoveryStartTime.IsZero() {
+		ks.RecoveryStartTime = time.Now()
+	}
 	ks.ErrorStartTime = time.Time{}
+	ks.FailedChecks = 0
 }
 
 type KeepersRole map[string]*KeeperRole
@@ -156,6 +245,9 @@ func (ksr KeepersRole) Add(id string, follow string) error {
 type KeeperRole struct {
 	ID     string
 	Follow string
+	// Spare standbys replicate without a replication slot on the followed
+	// instance. They are activated when a standby is lost.
+	Spare bool
 }
 
 func (kr *KeeperRole) Copy() *KeeperRole {
@@ -183,9 +275,15 @@ type ClusterView struct {
 	Version     int
 	Master      string
 	KeepersRole KeepersRole
-	ProxyConf   *ProxyConf
-	Config      *NilConfig
-	ChangeTime  time.Time
+	// Standbys chosen by the sentinel as synchronous standbys of the master
+	SynchronousStandbys []string
+	ProxyConf           *ProxyConf
+	Config              *NilConfig
+	ChangeTime          time.Time
+	// Times of the automatic failovers in the last failover window
+	Failovers []time.Time
+	// Why the failed master wasn't replaced (empty if not refused)
+	FailoverRefusedReason string
 }
 
 // NewClusterView return an initialized clusterView with Version: 0, zero
@@ -197,7 +295,8 @@ func NewClusterView() *ClusterView {
 	}
 }
 
-// Equals checks if the clusterViews are the same. It ignores the ChangeTime.
+// Equals checks if the clusterViews are the same. It ignores the ChangeTime
+// and the failovers information.
 func (cv *ClusterView) Equals(ncv *ClusterView) bool {
 	if cv == nil {
 		if ncv == nil {
@@ -206,8 +305,9 @@ func (cv *ClusterView) Equals(ncv *ClusterView) bool {
 		return false
 	}
 	return cv.Version == ncv.Version &&
-		cv.Master == cv.Master &&
+		cv.Master == ncv.Master &&
 		reflect.DeepEqual(cv.KeepersRole, ncv.KeepersRole) &&
+		util.CompareStringSlice(cv.SynchronousStandbys, ncv.SynchronousStandbys) &&
 		reflect.DeepEqual(cv.ProxyConf, ncv.ProxyConf) &&
 		reflect.DeepEqual(cv.Config, ncv.Config)
 }
@@ -218,9 +318,15 @@ func (cv *ClusterView) Copy() *ClusterView {
 	}
 	ncv := *cv
 	ncv.KeepersRole = cv.KeepersRole.Copy()
+	if cv.SynchronousStandbys != nil {
+		ncv.SynchronousStandbys = append([]string{}, cv.SynchronousStandbys...)
+	}
 	ncv.ProxyConf = cv.ProxyConf.Copy()
 	ncv.Config = cv.Config.Copy()
 	ncv.ChangeTime = cv.ChangeTime
+	if cv.Failovers != nil {
+		ncv.Failovers = append([]time.Time{}, cv.Failovers...)
+	}
 	return &ncv
 }
 
@@ -236,6 +342,18 @@ func (cv *ClusterView) GetFollowersIDs(id string) []string {
 	return followersIDs
 }
 
+// Returns a sorted list of the not spare followersIDs (the followers
+// using a replication slot)
+func (cv *ClusterView) GetActiveFollowersIDs(id string) []string {
+	followersIDs := []string{}
+	for _, followerID := range cv.GetFollowersIDs(id) {
+		if !cv.KeepersRole[followerID].Spare {
+			followersIDs = append(followersIDs, followerID)
+		}
+	}
+	return followersIDs
+}
+
 // A struct containing the KeepersState and the ClusterView since they need to be in sync
 type ClusterData struct {
 	KeepersState KeepersState
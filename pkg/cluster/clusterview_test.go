@@ -0,0 +1,70 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpreadByZone(t *testing.T) {
+	kss := KeepersState{
+		"01": &KeeperState{ID: "01", Zone: "a"},
+		"02": &KeeperState{ID: "02", Zone: "a"},
+		"03": &KeeperState{ID: "03", Zone: "b"},
+		"04": &KeeperState{ID: "04", Zone: "b"},
+		"05": &KeeperState{ID: "05", Zone: "c"},
+		"06": &KeeperState{ID: "06"},
+	}
+
+	tests := []struct {
+		ids      []string
+		lastZone string
+		out      []string
+	}{
+		{
+			ids: []string{},
+			out: []string{},
+		},
+		// No zones defined
+		{
+			ids: []string{"06"},
+			out: []string{"06"},
+		},
+		{
+			ids:      []string{"01", "02", "03", "04", "05"},
+			lastZone: "a",
+			out:      []string{"03", "05", "01", "04", "02"},
+		},
+		{
+			ids:      []string{"01", "02", "03", "04", "05"},
+			lastZone: "c",
+			out:      []string{"01", "03", "05", "02", "04"},
+		},
+		// Keepers without a zone come first when the last zone is defined
+		{
+			ids:      []string{"01", "02", "06"},
+			lastZone: "a",
+			out:      []string{"06", "01", "02"},
+		},
+	}
+
+	for i, tt := range tests {
+		out := kss.SpreadByZone(tt.ids, tt.lastZone)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("#%d: wrong ids: got: %v, want: %v", i, out, tt.out)
+		}
+	}
+}
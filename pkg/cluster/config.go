@@ -17,6 +17,8 @@ package cluster
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
@@ -27,25 +29,70 @@ const (
 	DefaultRequestTimeout          = 10 * time.Second
 	DefaultSleepInterval           = 5 * time.Second
 	DefaultKeeperFailInterval      = 20 * time.Second
+	DefaultKeeperFailChecks        = 1
+	DefaultKeeperRecoveryInterval  = 0
+	DefaultMaxFailovers            = 0
+	DefaultFailoverWindow          = 1 * time.Hour
 	DefaultMaxReplicationLag       = 600
 	DefaultMaxReplicationLagB      = 262144
 	DefaultMaxStandbysPerSender    = 3
+	DefaultDesiredStandbys         = 0
 	DefaultSynchronousReplication  = false
 	DefaultInitWithMultipleKeepers = false
 	DefaultUsePGRewind             = false
+	DefaultMaintenanceMode         = false
+	DefaultMinSynchronousStandbys  = 1
+	DefaultMaxSynchronousStandbys  = 1
+	DefaultSynchronousStandbysMode = SynchronousStandbysModeFirst
+	DefaultElectionPolicy          = ElectionPolicyXLogPos
+	DefaultStandbyCluster          = false
+)
+
+const (
+	// The master waits for the first (by list order) n connected
+	// synchronous standbys
+	SynchronousStandbysModeFirst = "first"
+	// The master waits for any n (quorum) of the synchronous standbys
+	SynchronousStandbysModeAny = "any"
+)
+
+const (
+	// Elect the standby with the highest xlog position, preferring the
+	// ones in a zone with other healthy replicas
+	ElectionPolicyXLogPos = "xlogpos"
+	// Elect the standby with the lowest replication lag in seconds
+	ElectionPolicyLag = "lag"
+	// Elect a standby in the same zone of the previous master if available
+	ElectionPolicyZone = "zone"
+	// Elect only the standbys in ElectionCandidates, in list order
+	ElectionPolicyList = "list"
 )
 
 type NilConfig struct {
 	RequestTimeout          *Duration          `json:"request_timeout,omitempty"`
 	SleepInterval           *Duration          `json:"sleep_interval,omitempty"`
 	KeeperFailInterval      *Duration          `json:"keeper_fail_interval,omitempty"`
+	KeeperFailChecks        *uint              `json:"keeper_fail_checks,omitempty"`
+	KeeperRecoveryInterval  *Duration          `json:"keeper_recovery_interval,omitempty"`
+	MaxFailovers            *uint              `json:"max_failovers,omitempty"`
+	FailoverWindow          *Duration          `json:"failover_window,omitempty"`
 	MaxReplicationLag       *uint              `json:"max_replication_lag,omitempty"`
 	MaxReplicationLagB      *uint              `json:"max_replication_lag_bytes,omitempty"`
 	MaxStandbysPerSender    *uint              `json:"max_standbys_per_sender,omitempty"`
+	DesiredStandbys         *uint              `json:"desired_standbys,omitempty"`
 	SynchronousReplication  *bool              `json:"synchronous_replication,omitempty"`
 	InitWithMultipleKeepers *bool              `json:"init_with_multiple_keepers,omitempty"`
 	UsePGRewind             *bool              `json:"use_pg_rewind,omitempty"`
 	PGParameters            *map[string]string `json:"pg_parameters,omitempty"`
+	MaintenanceMode         *bool              `json:"maintenance_mode,omitempty"`
+	MinSynchronousStandbys  *uint              `json:"min_synchronous_standbys,omitempty"`
+	MaxSynchronousStandbys  *uint              `json:"max_synchronous_standbys,omitempty"`
+	SynchronousStandbysMode *string            `json:"synchronous_standbys_mode,omitempty"`
+	ElectionPolicy          *string            `json:"election_policy,omitempty"`
+	ElectionCandidates      *[]string          `json:"election_candidates,omitempty"`
+	StandbyCluster          *bool              `json:"standby_cluster,omitempty"`
+	StandbyClusterPrimary   *string            `json:"standby_cluster_primary,omitempty"`
+	StandbyClusterSlotName  *string            `json:"standby_cluster_slot_name,omitempty"`
 }
 
 type Config struct {
@@ -55,6 +102,17 @@ type Config struct {
 	SleepInterval time.Duration
 	// Interval after the first fail to declare a keeper as not healthy.
 	KeeperFailInterval time.Duration
+	// Number of consecutive failed checks (in addition to
+	// KeeperFailInterval) to declare a keeper as not healthy.
+	KeeperFailChecks uint
+	// Interval a not healthy keeper must be responding to be declared
+	// healthy again.
+	KeeperRecoveryInterval time.Duration
+	// Max number of automatic failovers in FailoverWindow (0 means no
+	// limit). Further failovers are refused.
+	MaxFailovers uint
+	// Time window used to count the automatic failovers
+	FailoverWindow time.Duration
 	// Maximum possible lag between master and standbys (in seconds)
 	MaxReplicationLag uint
 	// Maximum possible lag between master and standbys (in bytes)
@@ -62,6 +120,9 @@ type Config struct {
 	// Max number of standbys for every sender. A sender can be a master or
 	// another standby (with cascading replication).
 	MaxStandbysPerSender uint
+	// Number of replicating standbys (0 means all the keepers). The other
+	// keepers are kept as spare standbys.
+	DesiredStandbys uint
 	// Use Synchronous replication between master and its standbys
 	SynchronousReplication bool
 	// Choose a random initial master when multiple keeper are registered
@@ -70,6 +131,30 @@ type Config struct {
 	UsePGRewind bool
 	// Map of postgres parameters
 	PGParameters map[string]string
+	// Don't change the master or the keepers roles (automatic failover
+	// paused)
+	MaintenanceMode bool
+	// Number of synchronous standbys that must acknowledge a transaction
+	// commit (when synchronous replication is enabled)
+	MinSynchronousStandbys uint
+	// Max number of standbys chosen as synchronous standbys
+	MaxSynchronousStandbys uint
+	// How the master waits for the synchronous standbys (first or any)
+	SynchronousStandbysMode string
+	// How the new master is chosen between the standbys (xlogpos, lag,
+	// zone or list)
+	ElectionPolicy string
+	// IDs of the keepers that can be elected, in order of preference, with
+	// the list election policy
+	ElectionCandidates []string
+	// Run the cluster as a standby of a remote primary: the master keeper
+	// follows StandbyClusterPrimary instead of being promoted
+	StandbyCluster bool
+	// Connection string of the remote primary followed by the master keeper
+	// with StandbyCluster
+	StandbyClusterPrimary string
+	// Replication slot to use on the remote primary (none if empty)
+	StandbyClusterSlotName string
 }
 
 func StringP(s string) *string {
@@ -96,6 +181,12 @@ func MapStringP(m map[string]string) *map[string]string {
 	return &nm
 }
 
+func StringSliceP(s []string) *[]string {
+	ns := make([]string, len(s))
+	copy(ns, s)
+	return &ns
+}
+
 type nilConfig NilConfig
 
 func (c *NilConfig) UnmarshalJSON(in []byte) error {
@@ -110,6 +201,121 @@ func (c *NilConfig) UnmarshalJSON(in []byte) error {
 	return nil
 }
 
+// ParseNilConfig parses and validates the provided config data. Differently
+// from json.Unmarshal it rejects the unknown keys (usually typos that would
+// be silently ignored).
+func ParseNilConfig(data []byte) (*NilConfig, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, k := range ConfigKeys() {
+		known[k] = true
+	}
+	unknown := []string{}
+	for k := range keys {
+		if !known[k] {
+			unknown = append(unknown, fmt.Sprintf("%q", k))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+	}
+	var c *NilConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c == nil {
+		c = &NilConfig{}
+	}
+	return c, nil
+}
+
+// ConfigKeys returns the json keys of the config
+func ConfigKeys() []string {
+	keys := []string{}
+	t := reflect.TypeOf(NilConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag != "" && tag != "-" {
+			keys = append(keys, tag)
+		}
+	}
+	return keys
+}
+
+// CheckUpdate returns an error if c cannot replace the prev config. Once the
+// cluster is initialized, init_with_multiple_keepers cannot be changed and
+// standby_cluster cannot be enabled (an existing primary cannot become a
+// standby of another cluster, it can only be promoted).
+func (c *NilConfig) CheckUpdate(prev *NilConfig, initialized bool) error {
+	if !initialized {
+		return nil
+	}
+	if prev == nil {
+		prev = &NilConfig{}
+	}
+	prevCfg := prev.ToConfig()
+	cfg := c.ToConfig()
+	if prevCfg.InitWithMultipleKeepers != cfg.InitWithMultipleKeepers {
+		return fmt.Errorf("init_with_multiple_keepers cannot be changed on an initialized cluster")
+	}
+	if !prevCfg.StandbyCluster && cfg.StandbyCluster {
+		return fmt.Errorf("standby_cluster cannot be enabled on an initialized cluster")
+	}
+	return nil
+}
+
+// ConfigDiff returns the keys changed between the prev and c configs as
+// "key: prev value -> new value" lines. Keys not defined are reported as
+// "(default)".
+func ConfigDiff(prev, c *NilConfig) ([]string, error) {
+	prevValues, err := configValues(prev)
+	if err != nil {
+		return nil, err
+	}
+	values, err := configValues(c)
+	if err != nil {
+		return nil, err
+	}
+	diff := []string{}
+	for _, k := range ConfigKeys() {
+		pv, ok := prevValues[k]
+		if !ok {
+			pv = "(default)"
+		}
+		v, ok := values[k]
+		if !ok {
+			v = "(default)"
+		}
+		if pv != v {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", k, pv, v))
+		}
+	}
+	return diff, nil
+}
+
+func configValues(c *NilConfig) (map[string]string, error) {
+	values := map[string]string{}
+	if c == nil {
+		return values, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	for k, v := range keys {
+		values[k] = string(v)
+	}
+	return values, nil
+}
+
 func (c *NilConfig) Copy() *NilConfig {
 	if c == nil {
 		return c
@@ -124,6 +330,18 @@ func (c *NilConfig) Copy() *NilConfig {
 	if c.KeeperFailInterval != nil {
 		nc.KeeperFailInterval = DurationP(*c.KeeperFailInterval)
 	}
+	if c.KeeperFailChecks != nil {
+		nc.KeeperFailChecks = UintP(*c.KeeperFailChecks)
+	}
+	if c.KeeperRecoveryInterval != nil {
+		nc.KeeperRecoveryInterval = DurationP(*c.KeeperRecoveryInterval)
+	}
+	if c.MaxFailovers != nil {
+		nc.MaxFailovers = UintP(*c.MaxFailovers)
+	}
+	if c.FailoverWindow != nil {
+		nc.FailoverWindow = DurationP(*c.FailoverWindow)
+	}
 	if c.MaxReplicationLag != nil {
 		nc.MaxReplicationLag = UintP(*c.MaxReplicationLag)
 	}
@@ -133,6 +351,9 @@ func (c *NilConfig) Copy() *NilConfig {
 	if c.MaxStandbysPerSender != nil {
 		nc.MaxStandbysPerSender = UintP(*c.MaxStandbysPerSender)
 	}
+	if c.DesiredStandbys != nil {
+		nc.DesiredStandbys = UintP(*c.DesiredStandbys)
+	}
 	if c.SynchronousReplication != nil {
 		nc.SynchronousReplication = BoolP(*c.SynchronousReplication)
 	}
@@ -145,6 +366,33 @@ func (c *NilConfig) Copy() *NilConfig {
 	if c.PGParameters != nil {
 		nc.PGParameters = MapStringP(*c.PGParameters)
 	}
+	if c.MaintenanceMode != nil {
+		nc.MaintenanceMode = BoolP(*c.MaintenanceMode)
+	}
+	if c.MinSynchronousStandbys != nil {
+		nc.MinSynchronousStandbys = UintP(*c.MinSynchronousStandbys)
+	}
+	if c.MaxSynchronousStandbys != nil {
+		nc.MaxSynchronousStandbys = UintP(*c.MaxSynchronousStandbys)
+	}
+	if c.SynchronousStandbysMode != nil {
+		nc.SynchronousStandbysMode = StringP(*c.SynchronousStandbysMode)
+	}
+	if c.ElectionPolicy != nil {
+		nc.ElectionPolicy = StringP(*c.ElectionPolicy)
+	}
+	if c.ElectionCandidates != nil {
+		nc.ElectionCandidates = StringSliceP(*c.ElectionCandidates)
+	}
+	if c.StandbyCluster != nil {
+		nc.StandbyCluster = BoolP(*c.StandbyCluster)
+	}
+	if c.StandbyClusterPrimary != nil {
+		nc.StandbyClusterPrimary = StringP(*c.StandbyClusterPrimary)
+	}
+	if c.StandbyClusterSlotName != nil {
+		nc.StandbyClusterSlotName = StringP(*c.StandbyClusterSlotName)
+	}
 	return &nc
 }
 
@@ -186,6 +434,15 @@ func (c *NilConfig) Validate() error {
 	if c.KeeperFailInterval != nil && (*c.KeeperFailInterval).Duration < 0 {
 		return fmt.Errorf("keeper_fail_interval must be positive")
 	}
+	if c.KeeperFailChecks != nil && *c.KeeperFailChecks < 1 {
+		return fmt.Errorf("keeper_fail_checks must be at least 1")
+	}
+	if c.KeeperRecoveryInterval != nil && (*c.KeeperRecoveryInterval).Duration < 0 {
+		return fmt.Errorf("keeper_recovery_interval must be positive")
+	}
+	if c.FailoverWindow != nil && (*c.FailoverWindow).Duration <= 0 {
+		return fmt.Errorf("failover_window must be greater than 0")
+	}
 	if c.MaxReplicationLag != nil && *c.MaxReplicationLag < 0 {
 		return fmt.Errorf("max_replication_lag must be positive")
 	}
@@ -196,6 +453,44 @@ func (c *NilConfig) Validate() error {
 	if c.MaxStandbysPerSender != nil && *c.MaxStandbysPerSender < 1 {
 		return fmt.Errorf("max_standbys_per_sender must be at least 1")
 	}
+	if c.MinSynchronousStandbys != nil && *c.MinSynchronousStandbys < 1 {
+		return fmt.Errorf("min_synchronous_standbys must be at least 1")
+	}
+	if c.MaxSynchronousStandbys != nil && *c.MaxSynchronousStandbys < 1 {
+		return fmt.Errorf("max_synchronous_standbys must be at least 1")
+	}
+	if c.MinSynchronousStandbys != nil && c.MaxSynchronousStandbys != nil && *c.MinSynchronousStandbys > *c.MaxSynchronousStandbys {
+		return fmt.Errorf("min_synchronous_standbys must be less or equal to max_synchronous_standbys")
+	}
+	if c.DesiredStandbys != nil && *c.DesiredStandbys > 0 && c.MinSynchronousStandbys != nil && *c.DesiredStandbys < *c.MinSynchronousStandbys {
+		return fmt.Errorf("desired_standbys must be greater or equal to min_synchronous_standbys")
+	}
+	if c.SynchronousStandbysMode != nil {
+		switch *c.SynchronousStandbysMode {
+		case SynchronousStandbysModeFirst:
+		case SynchronousStandbysModeAny:
+		default:
+			return fmt.Errorf("synchronous_standbys_mode must be %q or %q", SynchronousStandbysModeFirst, SynchronousStandbysModeAny)
+		}
+	}
+	if c.ElectionPolicy != nil {
+		switch *c.ElectionPolicy {
+		case ElectionPolicyXLogPos:
+		case ElectionPolicyLag:
+		case ElectionPolicyZone:
+		case ElectionPolicyList:
+			if c.ElectionCandidates == nil || len(*c.ElectionCandidates) == 0 {
+				return fmt.Errorf("election_candidates must be defined with the %q election policy", ElectionPolicyList)
+			}
+		default:
+			return fmt.Errorf("election_policy must be one of %q, %q, %q or %q", ElectionPolicyXLogPos, ElectionPolicyLag, ElectionPolicyZone, ElectionPolicyList)
+		}
+	}
+	if c.StandbyCluster != nil && *c.StandbyCluster {
+		if c.StandbyClusterPrimary == nil || *c.StandbyClusterPrimary == "" {
+			return fmt.Errorf("standby_cluster_primary must be defined with standby_cluster")
+		}
+	}
 	return nil
 }
 
@@ -209,6 +504,18 @@ func (c *NilConfig) MergeDefaults() {
 	if c.KeeperFailInterval == nil {
 		c.KeeperFailInterval = &Duration{DefaultKeeperFailInterval}
 	}
+	if c.KeeperFailChecks == nil {
+		c.KeeperFailChecks = UintP(DefaultKeeperFailChecks)
+	}
+	if c.KeeperRecoveryInterval == nil {
+		c.KeeperRecoveryInterval = &Duration{DefaultKeeperRecoveryInterval}
+	}
+	if c.MaxFailovers == nil {
+		c.MaxFailovers = UintP(DefaultMaxFailovers)
+	}
+	if c.FailoverWindow == nil {
+		c.FailoverWindow = &Duration{DefaultFailoverWindow}
+	}
 	if c.MaxReplicationLag == nil {
 		c.MaxReplicationLag = UintP(DefaultMaxReplicationLag)
 	}
@@ -218,6 +525,9 @@ func (c *NilConfig) MergeDefaults() {
 	if c.MaxStandbysPerSender == nil {
 		c.MaxStandbysPerSender = UintP(DefaultMaxStandbysPerSender)
 	}
+	if c.DesiredStandbys == nil {
+		c.DesiredStandbys = UintP(DefaultDesiredStandbys)
+	}
 	if c.SynchronousReplication == nil {
 		c.SynchronousReplication = BoolP(DefaultSynchronousReplication)
 	}
@@ -230,6 +540,33 @@ func (c *NilConfig) MergeDefaults() {
 	if c.PGParameters == nil {
 		c.PGParameters = &map[string]string{}
 	}
+	if c.MaintenanceMode == nil {
+		c.MaintenanceMode = BoolP(DefaultMaintenanceMode)
+	}
+	if c.MinSynchronousStandbys == nil {
+		c.MinSynchronousStandbys = UintP(DefaultMinSynchronousStandbys)
+	}
+	if c.MaxSynchronousStandbys == nil {
+		c.MaxSynchronousStandbys = UintP(DefaultMaxSynchronousStandbys)
+	}
+	if c.SynchronousStandbysMode == nil {
+		c.SynchronousStandbysMode = StringP(DefaultSynchronousStandbysMode)
+	}
+	if c.ElectionPolicy == nil {
+		c.ElectionPolicy = StringP(DefaultElectionPolicy)
+	}
+	if c.ElectionCandidates == nil {
+		c.ElectionCandidates = &[]string{}
+	}
+	if c.StandbyCluster == nil {
+		c.StandbyCluster = BoolP(DefaultStandbyCluster)
+	}
+	if c.StandbyClusterPrimary == nil {
+		c.StandbyClusterPrimary = StringP("")
+	}
+	if c.StandbyClusterSlotName == nil {
+		c.StandbyClusterSlotName = StringP("")
+	}
 }
 
 func (c *NilConfig) ToConfig() *Config {
@@ -239,13 +576,27 @@ func (c *NilConfig) ToConfig() *Config {
 		RequestTimeout:          (*nc.RequestTimeout).Duration,
 		SleepInterval:           (*nc.SleepInterval).Duration,
 		KeeperFailInterval:      (*nc.KeeperFailInterval).Duration,
+		KeeperFailChecks:        *nc.KeeperFailChecks,
+		KeeperRecoveryInterval:  (*nc.KeeperRecoveryInterval).Duration,
+		MaxFailovers:            *nc.MaxFailovers,
+		FailoverWindow:          (*nc.FailoverWindow).Duration,
 		MaxReplicationLag:       *nc.MaxReplicationLag,
 		MaxReplicationLagB:      *nc.MaxReplicationLagB,
 		MaxStandbysPerSender:    *nc.MaxStandbysPerSender,
+		DesiredStandbys:         *nc.DesiredStandbys,
 		SynchronousReplication:  *nc.SynchronousReplication,
 		InitWithMultipleKeepers: *nc.InitWithMultipleKeepers,
 		UsePGRewind:             *nc.UsePGRewind,
 		PGParameters:            *nc.PGParameters,
+		MaintenanceMode:         *nc.MaintenanceMode,
+		MinSynchronousStandbys:  *nc.MinSynchronousStandbys,
+		MaxSynchronousStandbys:  *nc.MaxSynchronousStandbys,
+		SynchronousStandbysMode: *nc.SynchronousStandbysMode,
+		ElectionPolicy:          *nc.ElectionPolicy,
+		ElectionCandidates:      *nc.ElectionCandidates,
+		StandbyCluster:          *nc.StandbyCluster,
+		StandbyClusterPrimary:   *nc.StandbyClusterPrimary,
+		StandbyClusterSlotName:  *nc.StandbyClusterSlotName,
 	}
 }
 
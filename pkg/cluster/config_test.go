@@ -56,6 +56,21 @@ func TestParseConfig(t *testing.T) {
 			cfg: nil,
 			err: fmt.Errorf("config validation failed: request_timeout must be positive"),
 		},
+		{
+			in:  `{ "keeper_fail_checks": 0 }`,
+			cfg: nil,
+			err: fmt.Errorf("config validation failed: keeper_fail_checks must be at least 1"),
+		},
+		{
+			in:  `{ "failover_window": "0s" }`,
+			cfg: nil,
+			err: fmt.Errorf("config validation failed: failover_window must be greater than 0"),
+		},
+		{
+			in:  `{ "keeper_fail_checks": 3, "keeper_recovery_interval": "1m", "max_failovers": 2, "failover_window": "30m" }`,
+			cfg: mergeDefaults(&NilConfig{KeeperFailChecks: UintP(3), KeeperRecoveryInterval: &Duration{time.Minute}, MaxFailovers: UintP(2), FailoverWindow: &Duration{30 * time.Minute}}).ToConfig(),
+			err: nil,
+		},
 		{
 			in:  `{ "sleep_interval": "-3s" }`,
 			cfg: nil,
@@ -71,9 +86,42 @@ func TestParseConfig(t *testing.T) {
 			cfg: nil,
 			err: fmt.Errorf("config validation failed: max_standbys_per_sender must be at least 1"),
 		},
+		{
+			in:  `{ "min_synchronous_standbys": 0 }`,
+			cfg: nil,
+			err: fmt.Errorf("config validation failed: min_synchronous_standbys must be at least 1"),
+		},
+		{
+			in:  `{ "min_synchronous_standbys": 3, "max_synchronous_standbys": 2 }`,
+			cfg: nil,
+			err: fmt.Errorf("config validation failed: min_synchronous_standbys must be less or equal to max_synchronous_standbys"),
+		},
+		{
+			in:  `{ "synchronous_standbys_mode": "all" }`,
+			cfg: nil,
+			err: fmt.Errorf(`config validation failed: synchronous_standbys_mode must be "first" or "any"`),
+		},
+		{
+			in:  `{ "election_policy": "random" }`,
+			cfg: nil,
+			err: fmt.Errorf(`config validation failed: election_policy must be one of "xlogpos", "lag", "zone" or "list"`),
+		},
+		{
+			in:  `{ "election_policy": "list" }`,
+			cfg: nil,
+			err: fmt.Errorf(`config validation failed: election_candidates must be defined with the "list" election policy`),
+		},
+		{
+			in:  `{ "standby_cluster": true }`,
+			cfg: nil,
+			err: fmt.Errorf("config validation failed: standby_cluster_primary must be defined with standby_cluster"),
+		},
 		// All options defined
 		{
-			in: `{ "request_timeout": "10s", "sleep_interval": "10s", "keeper_fail_interval": "100s", "max_standbys_per_sender": 5, "synchronous_replication": true, "init_with_multiple_keepers": true,
+			in: `{ "request_timeout": "10s", "sleep_interval": "10s", "keeper_fail_interval": "100s", "max_standbys_per_sender": 5, "synchronous_replication": true, "init_with_multiple_keepers": true, "maintenance_mode": true,
+			       "min_synchronous_standbys": 2, "max_synchronous_standbys": 3, "synchronous_standbys_mode": "any",
+			       "election_policy": "list", "election_candidates": ["keeper01", "keeper02"],
+			       "standby_cluster": true, "standby_cluster_primary": "host=10.0.0.1 port=5432", "standby_cluster_slot_name": "dr",
 			       "pg_parameters": {
 			         "param01": "value01"
 				}
@@ -85,6 +133,15 @@ func TestParseConfig(t *testing.T) {
 				MaxStandbysPerSender:    UintP(5),
 				SynchronousReplication:  BoolP(true),
 				InitWithMultipleKeepers: BoolP(true),
+				MaintenanceMode:         BoolP(true),
+				MinSynchronousStandbys:  UintP(2),
+				MaxSynchronousStandbys:  UintP(3),
+				SynchronousStandbysMode: StringP("any"),
+				ElectionPolicy:          StringP("list"),
+				ElectionCandidates:      &[]string{"keeper01", "keeper02"},
+				StandbyCluster:          BoolP(true),
+				StandbyClusterPrimary:   StringP("host=10.0.0.1 port=5432"),
+				StandbyClusterSlotName:  StringP("dr"),
 				PGParameters: &map[string]string{
 					"param01": "value01",
 				},
@@ -116,6 +173,163 @@ func TestParseConfig(t *testing.T) {
 	}
 }
 
+func TestParseNilConfig(t *testing.T) {
+	tests := []struct {
+		in  string
+		cfg *NilConfig
+		err error
+	}{
+		{
+			in:  "{}",
+			cfg: &NilConfig{},
+		},
+		{
+			in:  "null",
+			cfg: &NilConfig{},
+		},
+		{
+			in:  `{ "max_standbys_per_sender": 5 }`,
+			cfg: &NilConfig{MaxStandbysPerSender: UintP(5)},
+		},
+		{
+			in:  `{ "max_standby_per_sender": 5, "sleep_interval": "3s", "maintenance": true }`,
+			err: fmt.Errorf(`unknown config keys: "maintenance", "max_standby_per_sender"`),
+		},
+		{
+			in:  `{ "max_standbys_per_sender": 0 }`,
+			err: fmt.Errorf("config validation failed: max_standbys_per_sender must be at least 1"),
+		},
+		{
+			in:  `{ "desired_standbys": 2 }`,
+			cfg: &NilConfig{DesiredStandbys: UintP(2)},
+		},
+		{
+			in:  `{ "desired_standbys": 1, "min_synchronous_standbys": 2, "max_synchronous_standbys": 2 }`,
+			err: fmt.Errorf("config validation failed: desired_standbys must be greater or equal to min_synchronous_standbys"),
+		},
+	}
+
+	for i, tt := range tests {
+		cfg, err := ParseNilConfig([]byte(tt.in))
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, tt.cfg) {
+			t.Errorf(spew.Sprintf("#%d: wrong config: got: %#v, want: %#v", i, cfg, tt.cfg))
+		}
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	tests := []struct {
+		prev        *NilConfig
+		cfg         *NilConfig
+		initialized bool
+		err         error
+	}{
+		{
+			prev:        &NilConfig{},
+			cfg:         &NilConfig{MaxStandbysPerSender: UintP(5)},
+			initialized: true,
+		},
+		{
+			prev:        nil,
+			cfg:         &NilConfig{InitWithMultipleKeepers: BoolP(DefaultInitWithMultipleKeepers)},
+			initialized: true,
+		},
+		{
+			prev: &NilConfig{},
+			cfg:  &NilConfig{InitWithMultipleKeepers: BoolP(true)},
+		},
+		{
+			prev:        &NilConfig{},
+			cfg:         &NilConfig{InitWithMultipleKeepers: BoolP(true)},
+			initialized: true,
+			err:         fmt.Errorf("init_with_multiple_keepers cannot be changed on an initialized cluster"),
+		},
+		{
+			prev:        &NilConfig{},
+			cfg:         &NilConfig{StandbyCluster: BoolP(true), StandbyClusterPrimary: StringP("host=primary")},
+			initialized: true,
+			err:         fmt.Errorf("standby_cluster cannot be enabled on an initialized cluster"),
+		},
+		// A standby cluster can be promoted
+		{
+			prev:        &NilConfig{StandbyCluster: BoolP(true), StandbyClusterPrimary: StringP("host=primary")},
+			cfg:         &NilConfig{StandbyCluster: BoolP(false), StandbyClusterPrimary: StringP("host=primary")},
+			initialized: true,
+		},
+	}
+
+	for i, tt := range tests {
+		err := tt.cfg.CheckUpdate(tt.prev, tt.initialized)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	tests := []struct {
+		prev *NilConfig
+		cfg  *NilConfig
+		diff []string
+	}{
+		{
+			prev: &NilConfig{},
+			cfg:  &NilConfig{},
+			diff: []string{},
+		},
+		{
+			prev: nil,
+			cfg:  &NilConfig{SleepInterval: &Duration{3 * time.Second}},
+			diff: []string{`sleep_interval: (default) -> "3s"`},
+		},
+		{
+			prev: &NilConfig{
+				MaxStandbysPerSender: UintP(3),
+				MaintenanceMode:      BoolP(true),
+				PGParameters:         &map[string]string{"max_connections": "100"},
+			},
+			cfg: &NilConfig{
+				MaxStandbysPerSender: UintP(5),
+				PGParameters:         &map[string]string{"max_connections": "200"},
+			},
+			diff: []string{
+				"max_standbys_per_sender: 3 -> 5",
+				`pg_parameters: {"max_connections":"100"} -> {"max_connections":"200"}`,
+				"maintenance_mode: true -> (default)",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		diff, err := ConfigDiff(tt.prev, tt.cfg)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(diff, tt.diff) {
+			t.Errorf(spew.Sprintf("#%d: wrong diff: got: %#v, want: %#v", i, diff, tt.diff))
+		}
+	}
+}
+
 func mergeDefaults(c *NilConfig) *NilConfig {
 	c.MergeDefaults()
 	return c
@@ -0,0 +1,127 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventMasterElected     EventType = "MasterElected"
+	EventKeeperUnhealthy   EventType = "KeeperUnhealthy"
+	EventKeeperHealthy     EventType = "KeeperHealthy"
+	EventKeeperRemoved     EventType = "KeeperRemoved"
+	EventConfigChanged     EventType = "ConfigChanged"
+	EventProxyConfCleared  EventType = "ProxyConfCleared"
+	EventFailoverRefused   EventType = "FailoverRefused"
+	EventKeeperQuarantined EventType = "KeeperQuarantined"
+	EventKeeperReleased    EventType = "KeeperReleased"
+)
+
+// Event is a change of the cluster state made by the leader sentinel
+type Event struct {
+	Time time.Time
+	Type EventType
+	// KeeperID is the keeper the event refers to (if any)
+	KeeperID string
+	// ClusterViewVersion is the version of the cluster view when the event
+	// happened
+	ClusterViewVersion int
+	Message            string
+}
+
+type Events []*Event
+
+func NewEvent(eventType EventType, keeperID string, cvVersion int, format string, args ...interface{}) *Event {
+	return &Event{
+		Time:               time.Now(),
+		Type:               eventType,
+		KeeperID:           keeperID,
+		ClusterViewVersion: cvVersion,
+		Message:            fmt.Sprintf(format, args...),
+	}
+}
+
+// NewEvents returns the events describing the changes between the previous
+// and the new keepers state and cluster view.
+func NewEvents(prevKSS KeepersState, prevCV *ClusterView, kss KeepersState, cv *ClusterView) Events {
+	events := Events{}
+	if cv == nil {
+		return events
+	}
+	if prevCV == nil {
+		prevCV = NewClusterView()
+	}
+
+	if cv.Master != "" && cv.Master != prevCV.Master {
+		if prevCV.Master == "" {
+			events = append(events, NewEvent(EventMasterElected, cv.Master, cv.Version, "keeper %q elected as initial master", cv.Master))
+		} else {
+			events = append(events, NewEvent(EventMasterElected, cv.Master, cv.Version, "keeper %q elected as master replacing keeper %q", cv.Master, prevCV.Master))
+		}
+	}
+
+	for _, id := range kss.SortedKeys() {
+		k := kss[id]
+		prevK, ok := prevKSS[id]
+		if !ok {
+			// A new keeper can be quarantined at its first check
+			if k.Quarantined() {
+				events = append(events, NewEvent(EventKeeperQuarantined, id, cv.Version, "keeper %q quarantined since %s", id, k.QuarantineReason))
+			}
+			continue
+		}
+		if prevK.Healthy && !k.Healthy {
+			events = append(events, NewEvent(EventKeeperUnhealthy, id, cv.Version, "keeper %q marked as unhealthy", id))
+		}
+		if !prevK.Healthy && k.Healthy {
+			events = append(events, NewEvent(EventKeeperHealthy, id, cv.Version, "keeper %q is healthy again", id))
+		}
+		if !prevK.Quarantined() && k.Quarantined() {
+			events = append(events, NewEvent(EventKeeperQuarantined, id, cv.Version, "keeper %q quarantined since %s", id, k.QuarantineReason))
+		}
+		if prevK.Quarantined() && !k.Quarantined() {
+			events = append(events, NewEvent(EventKeeperReleased, id, cv.Version, "keeper %q released from quarantine", id))
+		}
+	}
+	for _, id := range prevKSS.SortedKeys() {
+		if _, ok := kss[id]; !ok {
+			// The sentinel removes an unhealthy standby in the same check
+			// that marks it as unhealthy
+			if prevKSS[id].Healthy {
+				events = append(events, NewEvent(EventKeeperUnhealthy, id, cv.Version, "keeper %q marked as unhealthy", id))
+			}
+			events = append(events, NewEvent(EventKeeperRemoved, id, cv.Version, "keeper %q removed", id))
+		}
+	}
+
+	if prevCV.Version != 0 && !reflect.DeepEqual(prevCV.Config, cv.Config) {
+		events = append(events, NewEvent(EventConfigChanged, "", cv.Version, "cluster config changed"))
+	}
+
+	if cv.FailoverRefusedReason != "" && prevCV.FailoverRefusedReason == "" {
+		events = append(events, NewEvent(EventFailoverRefused, cv.Master, cv.Version, "failover of master %q refused: %s", cv.Master, cv.FailoverRefusedReason))
+	}
+
+	if prevCV.ProxyConf != nil && cv.ProxyConf == nil {
+		events = append(events, NewEvent(EventProxyConfCleared, "", cv.Version, "proxy configuration cleared, proxies will close connections to the master"))
+	}
+
+	return events
+}
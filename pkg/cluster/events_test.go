@@ -0,0 +1,171 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewEvents(t *testing.T) {
+	cv := &ClusterView{
+		Version:   2,
+		Master:    "01",
+		ProxyConf: &ProxyConf{Host: "01", Port: "01"},
+		Config:    &NilConfig{},
+	}
+
+	tests := []struct {
+		prevKSS KeepersState
+		prevCV  *ClusterView
+		kss     KeepersState
+		cv      *ClusterView
+		events  []EventType
+	}{
+		// No changes
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			prevCV: cv,
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			cv:     cv,
+			events: []EventType{},
+		},
+		// Initial master
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+			},
+			prevCV: &ClusterView{Version: 1, Config: &NilConfig{}},
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+			},
+			cv:     cv,
+			events: []EventType{EventMasterElected},
+		},
+		// Failover, the unhealthy standby 03 is also removed
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+				"03": &KeeperState{ID: "03", Healthy: true},
+			},
+			prevCV: cv,
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: false},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			cv:     &ClusterView{Version: 3, Master: "02", Config: &NilConfig{}},
+			events: []EventType{EventMasterElected, EventKeeperUnhealthy, EventKeeperUnhealthy, EventKeeperRemoved, EventProxyConfCleared},
+		},
+		// Keeper healthy again and config changed
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: false},
+			},
+			prevCV: cv,
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			cv: &ClusterView{
+				Version:   3,
+				Master:    "01",
+				ProxyConf: &ProxyConf{Host: "01", Port: "01"},
+				Config:    &NilConfig{SynchronousReplication: BoolP(true)},
+			},
+			events: []EventType{EventKeeperHealthy, EventConfigChanged},
+		},
+		// Failover refused, reported only the first time
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			prevCV: cv,
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: false},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			cv: &ClusterView{
+				Version:               2,
+				Master:                "01",
+				ProxyConf:             &ProxyConf{Host: "01", Port: "01"},
+				Config:                &NilConfig{},
+				FailoverRefusedReason: "max failovers reached",
+			},
+			events: []EventType{EventKeeperUnhealthy, EventFailoverRefused},
+		},
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: false},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			prevCV: &ClusterView{
+				Version:               2,
+				Master:                "01",
+				ProxyConf:             &ProxyConf{Host: "01", Port: "01"},
+				Config:                &NilConfig{},
+				FailoverRefusedReason: "max failovers reached",
+			},
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: false},
+				"02": &KeeperState{ID: "02", Healthy: true},
+			},
+			cv: &ClusterView{
+				Version:               2,
+				Master:                "01",
+				ProxyConf:             &ProxyConf{Host: "01", Port: "01"},
+				Config:                &NilConfig{},
+				FailoverRefusedReason: "max failovers reached",
+			},
+			events: []EventType{},
+		},
+		// Keepers quarantined (also new ones) and released
+		{
+			prevKSS: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true},
+				"03": &KeeperState{ID: "03", Healthy: true, QuarantineReason: "different system ID"},
+			},
+			prevCV: cv,
+			kss: KeepersState{
+				"01": &KeeperState{ID: "01", Healthy: true},
+				"02": &KeeperState{ID: "02", Healthy: true, QuarantineReason: "different system ID"},
+				"03": &KeeperState{ID: "03", Healthy: true},
+				"04": &KeeperState{ID: "04", Healthy: true, QuarantineReason: "different system ID"},
+			},
+			cv:     cv,
+			events: []EventType{EventKeeperQuarantined, EventKeeperReleased, EventKeeperQuarantined},
+		},
+	}
+
+	for i, tt := range tests {
+		events := NewEvents(tt.prevKSS, tt.prevCV, tt.kss, tt.cv)
+		eventTypes := []EventType{}
+		for _, e := range events {
+			eventTypes = append(eventTypes, e.Type)
+		}
+		if !reflect.DeepEqual(eventTypes, tt.events) {
+			t.Errorf("#%d: wrong events: got: %v, want: %v", i, eventTypes, tt.events)
+		}
+	}
+}
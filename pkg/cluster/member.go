@@ -14,7 +14,11 @@
 
 package cluster
 
-import "github.com/gravitational/stolon/common"
+import (
+	"time"
+
+	"github.com/gravitational/stolon/common"
+)
 
 type Keeper struct {
 	ClusterViewVersion int
@@ -29,6 +33,21 @@ type KeeperInfo struct {
 	Port               string
 	PGListenAddress    string
 	PGPort             string
+	// Priority for master election, keepers with an higher priority are
+	// preferred
+	Priority int
+	// NoFailover keepers are never elected as master
+	NoFailover bool
+	// NoSync keepers are never used as synchronous standbys
+	NoSync bool
+	// RecoveryMinApplyDelay is the WAL apply delay of a delayed replica
+	// (0 if the keeper isn't delayed)
+	RecoveryMinApplyDelay time.Duration
+	// Zone is the failure domain (eg. availability zone) of the keeper
+	Zone string
+	// Fenced is true when the keeper stopped or made read-only its postgres
+	// instance since it cannot safely act as master
+	Fenced bool
 }
 
 func (k *KeeperInfo) Copy() *KeeperInfo {
@@ -73,6 +92,9 @@ type PostgresState struct {
 	XLogPos          uint64
 	ReplicationLag   uint
 	TimelinesHistory PostgresTimeLinesHistory
+	// Replication state of the followers by keeper id, as reported by the
+	// master pg_stat_replication (only reported by a master)
+	Followers map[string]*ReplicationStat
 }
 
 func (p *PostgresState) Copy() *PostgresState {
@@ -81,9 +103,49 @@ func (p *PostgresState) Copy() *PostgresState {
 	}
 	np := *p
 	np.TimelinesHistory = p.TimelinesHistory.Copy()
+	if p.Followers != nil {
+		np.Followers = make(map[string]*ReplicationStat, len(p.Followers))
+		for id, rs := range p.Followers {
+			np.Followers[id] = rs.Copy()
+		}
+	}
 	return &np
 }
 
+// ReplicationStat is the replication state of a follower as seen by its
+// sender
+type ReplicationStat struct {
+	// walsender state (startup, catchup, streaming)
+	State string
+	// xlog positions sent to the follower and written, flushed and replayed
+	// by it
+	SentLocation   uint64
+	WriteLocation  uint64
+	FlushLocation  uint64
+	ReplayLocation uint64
+	// Bytes between the sender current xlog position and the follower
+	// replayed position
+	ReplayLag uint64
+	// Seconds since the last xlog flushed and replayed by the follower was
+	// written by the sender (postgres >= 10, nil when not reported)
+	FlushLagTime  *uint
+	ReplayLagTime *uint
+}
+
+func (rs *ReplicationStat) Copy() *ReplicationStat {
+	if rs == nil {
+		return nil
+	}
+	nrs := *rs
+	if rs.FlushLagTime != nil {
+		nrs.FlushLagTime = UintP(*rs.FlushLagTime)
+	}
+	if rs.ReplayLagTime != nil {
+		nrs.ReplayLagTime = UintP(*rs.ReplayLagTime)
+	}
+	return &nrs
+}
+
 type KeepersDiscoveryInfo []*KeeperDiscoveryInfo
 
 type KeeperDiscoveryInfo struct {
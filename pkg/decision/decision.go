@@ -0,0 +1,839 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package decision contains the sentinel decision logic: starting from the
+// current cluster data it computes the new keepers state and cluster view.
+// It doesn't have side effects so it can also be used to simulate what the
+// sentinel will do.
+package decision
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+	"github.com/gravitational/stolon/pkg/util"
+
+	"github.com/coreos/pkg/capnslog"
+	"github.com/davecgh/go-spew/spew"
+)
+
+var log = capnslog.NewPackageLogger("github.com/gravitational/stolon/pkg", "decision")
+
+// Decider takes the sentinel decisions using the provided cluster config.
+type Decider struct {
+	cfg    *cluster.Config
+	policy ElectionPolicy
+}
+
+func NewDecider(cfg *cluster.Config) *Decider {
+	return &Decider{cfg: cfg, policy: NewElectionPolicy(cfg)}
+}
+
+// CheckStandby returns an error describing why the keeper with the provided
+// id cannot be elected as the new master replacing master.
+func (d *Decider) CheckStandby(cv *cluster.ClusterView, keepersState cluster.KeepersState, id string, master string) error {
+	k, ok := keepersState[id]
+	if !ok {
+		return fmt.Errorf("keeper state not available")
+	}
+	if id == master {
+		return fmt.Errorf("it's the current master")
+	}
+	if !k.Healthy {
+		return fmt.Errorf("it's not healthy")
+	}
+	if k.NoFailover {
+		return fmt.Errorf("it's tagged as nofailover")
+	}
+	// Checked before the replication lag since a delayed replica can be up
+	// to date but its xlog isn't replayed yet
+	if k.Delayed() {
+		return fmt.Errorf("keeper is delayed (recovery min apply delay %s)", k.RecoveryMinApplyDelay)
+	}
+	if k.Quarantined() {
+		return fmt.Errorf("it's quarantined: %s", k.QuarantineReason)
+	}
+	if k.Fenced {
+		return fmt.Errorf("it's fenced")
+	}
+	if k.ClusterViewVersion != cv.Version {
+		return fmt.Errorf("its clusterView version (%d) is different that the actual one (%d)", k.ClusterViewVersion, cv.Version)
+	}
+	if k.PGState == nil {
+		return fmt.Errorf("its pg state is unknown")
+	}
+	masterState := keepersState[master]
+	if masterState == nil || masterState.PGState == nil {
+		return fmt.Errorf("master pg state is unknown")
+	}
+	if masterState.PGState.SystemID != k.PGState.SystemID {
+		return fmt.Errorf("its system ID (%s) is different than the master system ID (%s)", k.PGState.SystemID, masterState.PGState.SystemID)
+	}
+	if masterState.PGState.TimelineID != k.PGState.TimelineID {
+		return fmt.Errorf("its pg timeline (%d) is different than master timeline (%d)", k.PGState.TimelineID, masterState.PGState.TimelineID)
+	}
+	if lag := standbyReplicationLag(keepersState, id, master); lag >= d.cfg.MaxReplicationLag {
+		return fmt.Errorf("its replication lag (%d) more than maximum possible lag (%d)", lag, d.cfg.MaxReplicationLag)
+	}
+
+	var replicationLagB uint64
+	xLogPos := standbyXLogPos(keepersState, id, master)
+	if masterState.PGState.XLogPos > xLogPos {
+		replicationLagB = masterState.PGState.XLogPos - xLogPos
+	} else {
+		replicationLagB = xLogPos - masterState.PGState.XLogPos
+	}
+	if replicationLagB >= uint64(d.cfg.MaxReplicationLagB) {
+		return fmt.Errorf("its replication lag in bytes (%d) more than maximum possible lag (%d)", replicationLagB, d.cfg.MaxReplicationLagB)
+	}
+	return d.policy.CheckCandidate(keepersState, id, master)
+}
+
+// GetBestStandby returns the standby to elect as the new master. When
+// synchronous replication is enabled only the synchronous standbys are
+// considered. Between them it chooses the one with the highest priority and
+// then the one preferred by the election policy.
+func (d *Decider) GetBestStandby(cv *cluster.ClusterView, keepersState cluster.KeepersState, master string) (string, error) {
+	var bestID string
+	synchronousStandbys := d.failoverSynchronousStandbys(cv, keepersState, master)
+	for id, k := range keepersState {
+		log.Debugf(spew.Sprintf("id: %s, k: %#v", id, k))
+		if d.cfg.SynchronousReplication && len(synchronousStandbys) > 0 && !util.StringInSlice(synchronousStandbys, id) {
+			log.Warningf("ignoring node %q since it's not a synchronous standby the master waits for", id)
+			continue
+		}
+		if err := d.CheckStandby(cv, keepersState, id, master); err != nil {
+			log.Warningf("ignoring node %q since %v", id, err)
+			continue
+		}
+		if bestID == "" {
+			bestID = id
+			continue
+		}
+		best := keepersState[bestID]
+		if k.Priority != best.Priority {
+			if k.Priority > best.Priority {
+				bestID = id
+			}
+			continue
+		}
+		if d.policy.Better(keepersState, id, bestID, master) {
+			bestID = id
+		}
+	}
+	if bestID == "" {
+		return "", fmt.Errorf("no standbys available")
+	}
+	return bestID, nil
+}
+
+// failoverSynchronousStandbys returns the synchronous standbys that may have
+// received all the commits acknowledged by the master. In first mode the
+// master waits only for the first MinSynchronousStandbys streaming standbys
+// in the list, so the standbys listed after them, as last reported by the
+// master, are only potential synchronous standbys. Without the master
+// replication stats all the listed standbys are returned.
+func (d *Decider) failoverSynchronousStandbys(cv *cluster.ClusterView, keepersState cluster.KeepersState, master string) []string {
+	n := int(d.cfg.MinSynchronousStandbys)
+	if d.cfg.SynchronousStandbysMode == cluster.SynchronousStandbysModeAny || len(cv.SynchronousStandbys) <= n {
+		return cv.SynchronousStandbys
+	}
+	m, ok := keepersState[master]
+	if !ok || m.PGState == nil || m.PGState.Followers == nil {
+		return cv.SynchronousStandbys
+	}
+	streaming := 0
+	for i, id := range cv.SynchronousStandbys {
+		if rs, ok := m.PGState.Followers[id]; ok && rs.State == "streaming" {
+			streaming++
+		}
+		if streaming == n {
+			return cv.SynchronousStandbys[:i+1]
+		}
+	}
+	return cv.SynchronousStandbys
+}
+
+// UpdateKeepersState returns the new keepers state merging the current one
+// with the keepers info and pg states retrieved from the keepers.
+func (d *Decider) UpdateKeepersState(cv *cluster.ClusterView, keepersState cluster.KeepersState, keepersInfo cluster.KeepersInfo, keepersPGState map[string]*cluster.PostgresState) cluster.KeepersState {
+	// Create newKeepersState as a copy of the current keepersState
+	newKeepersState := keepersState.Copy()
+
+	// Add new keepersInfo to newKeepersState. New keepers are healthy
+	// unless a recovery interval is required.
+	for id, ki := range keepersInfo {
+		if _, ok := newKeepersState[id]; !ok {
+			if err := newKeepersState.NewFromKeeperInfo(ki); err != nil {
+				// This shouldn't happen
+				panic(err)
+			}
+			newKeepersState[id].Healthy = d.cfg.KeeperRecoveryInterval == 0
+		}
+	}
+
+	// Update keeperState with keepersInfo
+	for id, ki := range keepersInfo {
+		changed, err := newKeepersState[id].ChangedFromKeeperInfo(ki)
+		if err != nil {
+			// This shouldn't happen
+			panic(err)
+		}
+		if changed {
+			newKeepersState[id].UpdateFromKeeperInfo(ki)
+		}
+	}
+
+	// Update PGstate and mark the keepers without keeperInfo or PGstate
+	// as in error
+	for id, k := range newKeepersState {
+		_, ok := keepersInfo[id]
+		kpg, pgOK := keepersPGState[id]
+		if pgOK {
+			k.PGState = kpg
+		}
+		if ok && pgOK {
+			k.CleanError()
+		} else {
+			k.SetError()
+		}
+	}
+
+	// Update Healthy state
+	for _, k := range newKeepersState {
+		k.Healthy = d.isKeeperHealthy(k)
+	}
+
+	d.updateQuarantinedKeepers(cv, newKeepersState)
+
+	RemoveUnhealthyKeepers(cv, newKeepersState)
+
+	return newKeepersState
+}
+
+// updateQuarantinedKeepers quarantines the keepers with an instance
+// belonging to a different database (system identifier) than the master one
+// and releases the ones with the same database (for example after a
+// resync). The keepers with an unknown or uninitialized pg state keep their
+// current state.
+func (d *Decider) updateQuarantinedKeepers(cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	master, ok := keepersState[cv.Master]
+	if !ok || master.PGState == nil || master.PGState.SystemID == "" {
+		return
+	}
+	master.QuarantineReason = ""
+	for id, k := range keepersState {
+		if id == cv.Master || k.PGState == nil || !k.PGState.Initialized || k.PGState.SystemID == "" {
+			continue
+		}
+		if k.PGState.SystemID != master.PGState.SystemID {
+			reason := fmt.Sprintf("its system ID (%s) is different than the master system ID (%s)", k.PGState.SystemID, master.PGState.SystemID)
+			if !k.Quarantined() {
+				log.Warningf("quarantining keeper %q since %s", id, reason)
+			}
+			k.QuarantineReason = reason
+		} else if k.Quarantined() {
+			log.Infof("releasing keeper %q from quarantine", id)
+			k.QuarantineReason = ""
+		}
+	}
+}
+
+// RemoveUnhealthyKeepers deletes the unhealthy keepers, except the master
+// and the keepers waiting for the recovery interval, from keepersState.
+func RemoveUnhealthyKeepers(cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	for id, state := range keepersState {
+		if !state.Healthy && id != cv.Master && !state.ErrorStartTime.IsZero() {
+			delete(keepersState, id)
+		}
+	}
+}
+
+// UpdateClusterView returns the new cluster view for the provided keepers
+// state electing a new master if the current one is failed. In maintenance
+// mode the cluster view is never changed.
+func (d *Decider) UpdateClusterView(cv *cluster.ClusterView, keepersState cluster.KeepersState) (*cluster.ClusterView, error) {
+	if d.cfg.MaintenanceMode {
+		return cv.Copy(), nil
+	}
+
+	var wantedMasterID string
+	var failoverRefusedReason string
+	if cv.Master == "" {
+		if cv.Version != 1 {
+			return nil, fmt.Errorf("cluster view at version %d without a defined master. This shouldn't happen!", cv.Version)
+		}
+
+		log.Debugf("trying to find initial master")
+		// Check for an initial master
+		if len(keepersState) < 1 {
+			return nil, fmt.Errorf("cannot choose initial master, no keepers registered")
+		}
+		if len(keepersState) > 1 && !d.cfg.InitWithMultipleKeepers {
+			return nil, fmt.Errorf("cannot choose initial master, more than 1 keeper registered")
+		}
+		for _, id := range keepersState.SortedKeys() {
+			k := keepersState[id]
+			if k.NoFailover {
+				log.Infof("ignoring keeper %q since it's tagged as nofailover", id)
+				continue
+			}
+			if k.PGState == nil {
+				return nil, fmt.Errorf("cannot init cluster using keeper %q since its pg state is unknown", id)
+			}
+			if !k.PGState.Initialized {
+				return nil, fmt.Errorf("cannot init cluster using keeper %q since pg instance is not initializied", id)
+			}
+			log.Infof("initializing cluster with master: %q", id)
+			wantedMasterID = id
+			break
+		}
+		if wantedMasterID == "" {
+			return nil, fmt.Errorf("cannot choose initial master, all keepers are tagged as nofailover")
+		}
+	} else {
+		masterID := cv.Master
+		wantedMasterID = masterID
+
+		masterOK := true
+		master, ok := keepersState[masterID]
+		if !ok {
+			return nil, fmt.Errorf("keeper state for master %q not available. This shouldn't happen!", masterID)
+		}
+		log.Debugf(spew.Sprintf("masterState: %#v", master))
+
+		if !master.Healthy {
+			log.Infof("master is failed")
+			masterOK = false
+		}
+
+		if master.Fenced {
+			log.Infof("master is fenced")
+			masterOK = false
+		}
+
+		// Check that the wanted master is in master state (i.e. check that promotion from standby to master happened)
+		if !d.isKeeperConverged(master, cv) {
+			log.Infof("keeper %s not yet master", masterID)
+			masterOK = false
+		}
+
+		if !masterOK {
+			log.Infof("trying to find a standby to replace failed master")
+			bestStandby, err := d.GetBestStandby(cv, keepersState, masterID)
+			if err != nil {
+				log.Errorf("error trying to find the best standby: %v", err)
+			} else if err := d.checkFailoversRate(cv); err != nil {
+				log.Errorf("cannot replace failed master: %v", err)
+				failoverRefusedReason = err.Error()
+			} else {
+				if bestStandby != masterID {
+					log.Infof("electing new master: %q", bestStandby)
+					wantedMasterID = bestStandby
+				} else {
+					log.Infof("cannot find a good standby to replace failed master")
+				}
+			}
+		}
+	}
+
+	newCV := cv.Copy()
+	newKeepersRole := newCV.KeepersRole
+
+	// Add new keepersRole from keepersState
+	for id, _ := range keepersState {
+		if _, ok := newKeepersRole[id]; !ok {
+			if err := newKeepersRole.Add(id, ""); err != nil {
+				// This shouldn't happen
+				panic(err)
+			}
+		}
+	}
+
+	// Delete stale keepers
+	// if keeperstate map does not contain keeper id, which we get from
+	// clusterview map then delete it
+	for id := range newKeepersRole {
+		if _, ok := keepersState[id]; !ok {
+			delete(newKeepersRole, id)
+		}
+	}
+
+	// Setup master role
+	if cv.Master != wantedMasterID {
+		newCV.Master = wantedMasterID
+		newKeepersRole[wantedMasterID].Follow = ""
+		newKeepersRole[wantedMasterID].Spare = false
+	}
+
+	newCV.Failovers = d.recentFailovers(cv)
+	if cv.Master != "" && cv.Master != wantedMasterID {
+		newCV.Failovers = append(newCV.Failovers, time.Now())
+	}
+	newCV.FailoverRefusedReason = failoverRefusedReason
+
+	// Setup standbys
+	if cv.Master == wantedMasterID {
+		// wanted master is the previous one
+		masterState := keepersState[wantedMasterID]
+		// Set standbys to follow master only if it's healthy and converged to the current cv
+		if masterState.Healthy && d.isKeeperConverged(masterState, cv) {
+			d.updateSpareKeepers(cv, newCV, keepersState)
+			d.updateKeepersTree(cv, newCV, keepersState)
+		}
+	}
+
+	d.updateSynchronousStandbys(cv, newCV, keepersState)
+
+	d.updateProxyConf(cv, newCV, keepersState)
+
+	if !newCV.Equals(cv) {
+		newCV.Version = cv.Version + 1
+		newCV.ChangeTime = time.Now()
+	}
+	return newCV, nil
+}
+
+func (d *Decider) updateProxyConf(prevCV *cluster.ClusterView, cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	masterID := cv.Master
+	if prevCV.Master != masterID {
+		log.Infof("deleting proxyconf")
+		// Tell proxy to close connection to old master
+		cv.ProxyConf = nil
+		return
+	}
+
+	master, _ := keepersState[masterID]
+	if d.isKeeperConverged(master, prevCV) {
+		pc := &cluster.ProxyConf{
+			Host: master.PGListenAddress,
+			Port: master.PGPort,
+		}
+		prevPC := prevCV.ProxyConf
+		update := true
+		if prevPC != nil {
+			if prevPC.Host == pc.Host && prevPC.Port == pc.Port {
+				update = false
+			}
+		}
+		if update {
+			log.Infof("updating proxyconf to %s:%s", pc.Host, pc.Port)
+			cv.ProxyConf = pc
+		}
+	}
+	return
+}
+
+// updateSpareKeepers marks as spare the standbys exceeding DesiredStandbys.
+// The active standbys are chosen between the healthy ones preferring the
+// current synchronous standbys and then the current active standbys, so a lost
+// standby is replaced by a spare one. When there aren't enough healthy
+// standbys the current active ones are kept to not drop their replication
+// slots. Delayed replicas are always active and not counted.
+func (d *Decider) updateSpareKeepers(prevCV *cluster.ClusterView, cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	masterID := cv.Master
+	desiredStandbys := int(d.cfg.DesiredStandbys)
+
+	standbys := []string{}
+	for _, id := range keepersState.SortedKeys() {
+		kr, ok := cv.KeepersRole[id]
+		if !ok {
+			continue
+		}
+		if id == masterID || desiredStandbys == 0 || keepersState[id].Delayed() {
+			kr.Spare = false
+			continue
+		}
+		standbys = append(standbys, id)
+	}
+
+	wasActive := func(id string) bool {
+		kr, ok := prevCV.KeepersRole[id]
+		return ok && !kr.Spare
+	}
+	isHealthy := func(id string) bool {
+		k := keepersState[id]
+		return k.Healthy && !k.Quarantined()
+	}
+	active := []string{}
+	addActive := func(match func(id string) bool) {
+		for _, id := range standbys {
+			if len(active) >= desiredStandbys {
+				return
+			}
+			if match(id) && !util.StringInSlice(active, id) {
+				active = append(active, id)
+			}
+		}
+	}
+	addActive(func(id string) bool {
+		return isHealthy(id) && wasActive(id) && util.StringInSlice(prevCV.SynchronousStandbys, id)
+	})
+	addActive(func(id string) bool { return isHealthy(id) && wasActive(id) })
+	addActive(isHealthy)
+	addActive(wasActive)
+
+	for _, id := range standbys {
+		kr := cv.KeepersRole[id]
+		spare := !util.StringInSlice(active, id)
+		if spare && !kr.Spare {
+			log.Infof("keeper %q is now a spare standby", id)
+		} else if !spare && kr.Spare {
+			log.Infof("activating spare keeper %q", id)
+		}
+		kr.Spare = spare
+	}
+}
+
+// updateKeepersTree sets the keepers to follow in the cv building a
+// replication tree rooted at the master where every sender (the master or a
+// standby with cascading replication) has at most MaxStandbysPerSender
+// followers. The synchronous standbys always follow the master. The current
+// followed keepers are kept when still valid to avoid useless
+// reconfigurations, the other standbys follow the nearest (to the master)
+// healthy sender with free slots. Spare standbys are placed after the active
+// ones and are never senders.
+func (d *Decider) updateKeepersTree(prevCV *cluster.ClusterView, cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	masterID := cv.Master
+	maxFollowers := int(d.cfg.MaxStandbysPerSender)
+
+	standbys := []string{}
+	spares := []string{}
+	for _, id := range keepersState.SortedKeys() {
+		kr, ok := cv.KeepersRole[id]
+		if !ok || id == masterID {
+			continue
+		}
+		if kr.Spare {
+			spares = append(spares, id)
+		} else {
+			standbys = append(standbys, id)
+		}
+	}
+
+	follow := map[string]string{}
+	followersCount := map[string]int{}
+	senders := []string{masterID}
+	// distance of the senders from the master
+	depth := map[string]int{masterID: 0}
+	canFollow := func(id string, sender string) bool {
+		if _, ok := follow[id]; ok {
+			return false
+		}
+		if followersCount[sender] >= maxFollowers {
+			return false
+		}
+		if sender == masterID {
+			return true
+		}
+		// A delayed replica would also delay its followers
+		k, ok := keepersState[sender]
+		return ok && k.Healthy && !k.Delayed() && !k.Quarantined()
+	}
+	setFollow := func(id string, sender string) {
+		follow[id] = sender
+		followersCount[sender]++
+		if !cv.KeepersRole[id].Spare {
+			senders = append(senders, id)
+			depth[id] = depth[sender] + 1
+		}
+	}
+	placeStandbys := func(ids []string) {
+		// Keep the current valid followed keepers
+		for i := 0; i < len(senders); i++ {
+			sender := senders[i]
+			for _, id := range ids {
+				if prevCV.KeepersRole[id] != nil && prevCV.KeepersRole[id].Follow == sender && canFollow(id, sender) {
+					setFollow(id, sender)
+				}
+			}
+		}
+
+		// Place the remaining standbys
+		for _, id := range ids {
+			if _, ok := follow[id]; ok {
+				continue
+			}
+			bestSender := ""
+			for _, sender := range senders {
+				if canFollow(id, sender) && (bestSender == "" || depth[sender] < depth[bestSender]) {
+					bestSender = sender
+				}
+			}
+			if bestSender != "" {
+				setFollow(id, bestSender)
+			} else {
+				log.Warningf("no sender with free slots available for keeper %q, following the master", id)
+				follow[id] = masterID
+			}
+		}
+	}
+
+	// Synchronous standbys follow the master
+	for _, id := range prevCV.SynchronousStandbys {
+		if util.StringInSlice(standbys, id) && canFollow(id, masterID) {
+			setFollow(id, masterID)
+		}
+	}
+
+	placeStandbys(standbys)
+	placeStandbys(spares)
+
+	for id, sender := range follow {
+		cv.KeepersRole[id].Follow = sender
+	}
+}
+
+// updateSynchronousStandbys chooses the synchronous standbys of the cv master.
+// The candidates are the healthy not spare standbys following the master, not
+// tagged as nosync, not delayed and not quarantined. The current synchronous
+// standbys are kept when still valid to avoid useless changes. When less than
+// MinSynchronousStandbys candidates are available the previous synchronous
+// standbys (but not the delayed or quarantined ones) are kept to not lower
+// the requested durability (commits will block until enough of them are
+// back).
+func (d *Decider) updateSynchronousStandbys(prevCV *cluster.ClusterView, cv *cluster.ClusterView, keepersState cluster.KeepersState) {
+	if !d.cfg.SynchronousReplication {
+		cv.SynchronousStandbys = nil
+		return
+	}
+
+	masterID := cv.Master
+	minStandbys := int(d.cfg.MinSynchronousStandbys)
+	maxStandbys := int(d.cfg.MaxSynchronousStandbys)
+	if maxStandbys < minStandbys {
+		maxStandbys = minStandbys
+	}
+	var masterZone string
+	if master, ok := keepersState[masterID]; ok {
+		masterZone = master.Zone
+	}
+
+	isCandidate := func(id string) bool {
+		kr, ok := cv.KeepersRole[id]
+		if !ok || id == masterID || kr.Follow != masterID || kr.Spare {
+			return false
+		}
+		k, ok := keepersState[id]
+		return ok && k.Healthy && !k.NoSync && !k.Delayed() && !k.Quarantined()
+	}
+
+	synchronousStandbys := []string{}
+	// Keep the current valid synchronous standbys
+	for _, id := range prevCV.SynchronousStandbys {
+		if len(synchronousStandbys) >= maxStandbys {
+			break
+		}
+		if isCandidate(id) {
+			synchronousStandbys = append(synchronousStandbys, id)
+		}
+	}
+
+	// Add new synchronous standbys spreading them across zones
+	candidates := []string{}
+	for _, id := range keepersState.SortedKeys() {
+		if isCandidate(id) && !util.StringInSlice(synchronousStandbys, id) {
+			candidates = append(candidates, id)
+		}
+	}
+	for _, id := range keepersState.SpreadByZone(candidates, masterZone) {
+		if len(synchronousStandbys) >= maxStandbys {
+			break
+		}
+		synchronousStandbys = append(synchronousStandbys, id)
+	}
+
+	// Not enough valid standbys, keep the previous ones
+	for _, id := range prevCV.SynchronousStandbys {
+		if len(synchronousStandbys) >= minStandbys {
+			break
+		}
+		if _, ok := cv.KeepersRole[id]; !ok || id == masterID || util.StringInSlice(synchronousStandbys, id) {
+			continue
+		}
+		if k, ok := keepersState[id]; ok && (k.Delayed() || k.Quarantined()) {
+			continue
+		}
+		log.Warningf("keeping keeper %q as synchronous standby since there aren't enough valid standbys", id)
+		synchronousStandbys = append(synchronousStandbys, id)
+	}
+
+	sort.Strings(synchronousStandbys)
+	cv.SynchronousStandbys = keepersState.SpreadByZone(synchronousStandbys, masterZone)
+}
+
+// SwitchoverClusterView returns a new clusterView where targetID is the new master and
+// the current master is set to follow it. If targetID is empty the best
+// standby is chosen. The current master must be healthy and converged.
+func (d *Decider) SwitchoverClusterView(cv *cluster.ClusterView, keepersState cluster.KeepersState, targetID string) (*cluster.ClusterView, error) {
+	masterID := cv.Master
+	if masterID == "" {
+		return nil, fmt.Errorf("no master defined")
+	}
+	master, ok := keepersState[masterID]
+	if !ok {
+		return nil, fmt.Errorf("keeper state for master %q not available", masterID)
+	}
+	if !master.Healthy || !d.isKeeperConverged(master, cv) {
+		return nil, fmt.Errorf("master %q is not healthy or not converged", masterID)
+	}
+	if master.Fenced {
+		return nil, fmt.Errorf("master %q is fenced", masterID)
+	}
+
+	if targetID == "" {
+		bestStandby, err := d.GetBestStandby(cv, keepersState, masterID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find a standby to switchover to: %v", err)
+		}
+		targetID = bestStandby
+	} else if err := d.CheckStandby(cv, keepersState, targetID, masterID); err != nil {
+		return nil, fmt.Errorf("cannot switchover to keeper %q since %v", targetID, err)
+	}
+	if _, ok := cv.KeepersRole[targetID]; !ok {
+		return nil, fmt.Errorf("keeper %q has no role in the current cluster view", targetID)
+	}
+
+	newCV := cv.Copy()
+	newCV.Master = targetID
+	newCV.KeepersRole[targetID].Follow = ""
+	newCV.KeepersRole[targetID].Spare = false
+	newCV.KeepersRole[masterID].Follow = targetID
+
+	d.updateSynchronousStandbys(cv, newCV, keepersState)
+
+	d.updateProxyConf(cv, newCV, keepersState)
+
+	newCV.Version = cv.Version + 1
+	newCV.ChangeTime = time.Now()
+	return newCV, nil
+}
+
+// RemoveKeeper returns a new clusterView and keepersState without the keeper
+// id. The keeper cannot be the current master unless force is true, in this
+// case the best standby is elected as the new master like in an automatic
+// failover (so not in maintenance mode or exceeding the max failovers rate)
+// and the failover is recorded. The standbys following
+// the removed keeper are moved to another sender. The master keeper will drop
+// the removed keeper's replication slot since it's not a follower anymore.
+func (d *Decider) RemoveKeeper(cv *cluster.ClusterView, keepersState cluster.KeepersState, id string, force bool) (*cluster.ClusterView, cluster.KeepersState, error) {
+	_, inState := keepersState[id]
+	_, inRole := cv.KeepersRole[id]
+	if !inState && !inRole {
+		return nil, nil, fmt.Errorf("keeper %q doesn't exist", id)
+	}
+
+	// The removed keeper isn't a valid synchronous standby anymore
+	prevCV := cv.Copy()
+	synchronousStandbys := []string{}
+	for _, s := range prevCV.SynchronousStandbys {
+		if s != id {
+			synchronousStandbys = append(synchronousStandbys, s)
+		}
+	}
+	prevCV.SynchronousStandbys = synchronousStandbys
+
+	newCV := prevCV.Copy()
+	newKeepersState := keepersState.Copy()
+	if id == cv.Master {
+		if !force {
+			return nil, nil, fmt.Errorf("keeper %q is the current master", id)
+		}
+		if d.cfg.MaintenanceMode {
+			return nil, nil, fmt.Errorf("keeper %q is the current master and the cluster is in maintenance mode", id)
+		}
+		if err := d.checkFailoversRate(cv); err != nil {
+			return nil, nil, fmt.Errorf("cannot elect a new master: %v", err)
+		}
+		bestStandby, err := d.GetBestStandby(cv, keepersState, id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot find a standby to elect as the new master: %v", err)
+		}
+		newCV.Master = bestStandby
+		newCV.KeepersRole[bestStandby].Follow = ""
+		newCV.KeepersRole[bestStandby].Spare = false
+		newCV.Failovers = append(d.recentFailovers(cv), time.Now())
+	}
+	delete(newCV.KeepersRole, id)
+	delete(newKeepersState, id)
+
+	if newCV.Master != "" {
+		d.updateKeepersTree(prevCV, newCV, newKeepersState)
+		d.updateSynchronousStandbys(prevCV, newCV, newKeepersState)
+	}
+	if newCV.Master != cv.Master {
+		log.Infof("deleting proxyconf")
+		// Tell proxy to close connection to old master
+		newCV.ProxyConf = nil
+	}
+
+	newCV.Version = cv.Version + 1
+	newCV.ChangeTime = time.Now()
+	return newCV, newKeepersState, nil
+}
+
+// recentFailovers returns the cv failovers in the current failover window
+func (d *Decider) recentFailovers(cv *cluster.ClusterView) []time.Time {
+	var failovers []time.Time
+	windowStart := time.Now().Add(-d.cfg.FailoverWindow)
+	for _, t := range cv.Failovers {
+		if t.After(windowStart) {
+			failovers = append(failovers, t)
+		}
+	}
+	return failovers
+}
+
+// checkFailoversRate returns an error if another automatic failover would
+// exceed MaxFailovers in the failover window.
+func (d *Decider) checkFailoversRate(cv *cluster.ClusterView) error {
+	if d.cfg.MaxFailovers == 0 {
+		return nil
+	}
+	if failovers := d.recentFailovers(cv); len(failovers) >= int(d.cfg.MaxFailovers) {
+		return fmt.Errorf("max failovers (%d) in the last %s reached, the next failover is allowed after %s", d.cfg.MaxFailovers, d.cfg.FailoverWindow, failovers[len(failovers)-int(d.cfg.MaxFailovers)].Add(d.cfg.FailoverWindow).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// isKeeperHealthy returns the new health of the keeper. A healthy keeper
+// becomes unhealthy when it's failing since KeeperFailInterval and for at
+// least KeeperFailChecks consecutive checks. An unhealthy keeper becomes
+// healthy again when it's responding since KeeperRecoveryInterval.
+func (d *Decider) isKeeperHealthy(keeperState *cluster.KeeperState) bool {
+	if !keeperState.Healthy {
+		if !keeperState.ErrorStartTime.IsZero() {
+			return false
+		}
+		return !time.Now().Before(keeperState.RecoveryStartTime.Add(d.cfg.KeeperRecoveryInterval))
+	}
+	if keeperState.ErrorStartTime.IsZero() {
+		return true
+	}
+	if time.Now().After(keeperState.ErrorStartTime.Add(d.cfg.KeeperFailInterval)) && keeperState.FailedChecks >= int(d.cfg.KeeperFailChecks) {
+		return false
+	}
+	return true
+}
+
+func (d *Decider) isKeeperConverged(keeperState *cluster.KeeperState, cv *cluster.ClusterView) bool {
+	if keeperState.ClusterViewVersion != cv.Version {
+		if time.Now().After(cv.ChangeTime.Add(d.cfg.KeeperFailInterval)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,2547 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package decision
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/gravitational/stolon/pkg/cluster"
+)
+
+func TestUpdateClusterView(t *testing.T) {
+	tests := []struct {
+		cv           *cluster.ClusterView
+		keepersState cluster.KeepersState
+		outCV        *cluster.ClusterView
+		err          error
+	}{
+		{
+			cv:           cluster.NewClusterView(),
+			keepersState: nil,
+			outCV:        cluster.NewClusterView(),
+			err:          fmt.Errorf("cluster view at version 0 without a defined master. This shouldn't happen!"),
+		},
+		{
+			cv: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+			},
+			keepersState: nil,
+			outCV: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+			},
+			err: fmt.Errorf("cannot choose initial master, no keepers registered"),
+		},
+		// cluster initialization, one keeper
+		{
+			cv: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{PGState: &cluster.PostgresState{Initialized: true}},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+				},
+			},
+		},
+		// cluster initialization, too many keepers
+		{
+			cv: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{},
+				"02": &cluster.KeeperState{},
+			},
+			outCV: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+			},
+			err: fmt.Errorf("cannot choose initial master, more than 1 keeper registered"),
+		},
+		// cluster initialization, more then one keeper but InitWithMultipleKeepers == true
+		{
+			cv: &cluster.ClusterView{
+				Version:     1,
+				KeepersRole: cluster.KeepersRole{},
+				Config:      &cluster.NilConfig{InitWithMultipleKeepers: cluster.BoolP(true)},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{PGState: &cluster.PostgresState{Initialized: true}},
+				"02": &cluster.KeeperState{PGState: &cluster.PostgresState{Initialized: true}},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				Config: &cluster.NilConfig{InitWithMultipleKeepers: cluster.BoolP(true)},
+			},
+		},
+
+		// One master and one standby, both healthy: no change from previous cv
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+		},
+		// One master and one standby, master not healthy: standby elected as new master
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Unix(0, 0),
+					Healthy:            false,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				ProxyConf: nil,
+			},
+		},
+		// One master and one standby, master healthy but fenced: standby elected as new master
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					Fenced:             true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				ProxyConf: nil,
+			},
+		},
+		// From the previous test, new master (02) converged. Old master setup to follow new master (02).
+		{
+			cv: &cluster.ClusterView{
+				Version: 2,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				ProxyConf: nil,
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Unix(0, 0),
+					Healthy:            false,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 2,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 3,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: "02"},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "02", Port: "02"},
+			},
+		},
+
+		// One master and one standby, master not healthy, standby with old
+		// clusterview: no standby elected as new master.
+		{
+			cv: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 2,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Unix(0, 0),
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+		},
+		// One master and one standby, master not converged to current
+		// cv: standby elected as new master.
+		{
+			cv: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: nil,
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 2,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 3,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+				},
+				ProxyConf: nil,
+			},
+		},
+		// One master and one standby, master not healthy but cluster in
+		// maintenance mode: no change from previous cv
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+				Config:    &cluster.NilConfig{MaintenanceMode: cluster.BoolP(true)},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "01",
+					PGPort:             "01",
+					ErrorStartTime:     time.Unix(0, 0),
+					Healthy:            false,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+				"02": &cluster.KeeperState{
+					ClusterViewVersion: 1,
+					PGListenAddress:    "02",
+					PGPort:             "02",
+					ErrorStartTime:     time.Time{},
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						TimelineID: 0,
+					},
+				},
+			},
+			outCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+				Config:    &cluster.NilConfig{MaintenanceMode: cluster.BoolP(true)},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		var d *Decider
+		if tt.cv.Config == nil {
+			d = NewDecider(cluster.NewDefaultConfig())
+		} else {
+			d = NewDecider(tt.cv.Config.ToConfig())
+		}
+		outCV, err := d.UpdateClusterView(tt.cv, tt.keepersState)
+		t.Logf("test #%d", i)
+		t.Logf(spew.Sprintf("outCV: %#v", outCV))
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("got no error, wanted error: %v", tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("got error: %v, wanted error: %v", err, tt.err)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !outCV.Equals(tt.outCV) {
+				t.Errorf(spew.Sprintf("#%d: wrong outCV: got: %#v, want: %#v", i, outCV, tt.outCV))
+			}
+		}
+	}
+}
+
+func TestFailoversRate(t *testing.T) {
+	now := time.Now()
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{
+			ClusterViewVersion: 1,
+			ErrorStartTime:     time.Unix(0, 0),
+			Healthy:            false,
+			PGState:            &cluster.PostgresState{},
+		},
+		"02": &cluster.KeeperState{
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{},
+		},
+	}
+	config := &cluster.NilConfig{
+		MaxFailovers:   cluster.UintP(2),
+		FailoverWindow: &cluster.Duration{Duration: time.Hour},
+	}
+
+	tests := []struct {
+		cv            *cluster.ClusterView
+		master        string
+		failovers     int
+		refusedReason string
+	}{
+		// No previous failovers
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				Config: config,
+			},
+			master:    "02",
+			failovers: 1,
+		},
+		// Failovers outside the window are forgotten
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				Config:    config,
+				Failovers: []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-30 * time.Minute)},
+			},
+			master:    "02",
+			failovers: 2,
+		},
+		// Max failovers reached
+		{
+			cv: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+				},
+				Config:    config,
+				Failovers: []time.Time{now.Add(-40 * time.Minute), now.Add(-30 * time.Minute)},
+			},
+			master:        "01",
+			failovers:     2,
+			refusedReason: fmt.Sprintf("max failovers (2) in the last 1h0m0s reached, the next failover is allowed after %s", now.Add(20*time.Minute).Format(time.RFC3339)),
+		},
+	}
+
+	for i, tt := range tests {
+		d := NewDecider(tt.cv.Config.ToConfig())
+		outCV, err := d.UpdateClusterView(tt.cv, keepersState)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if outCV.Master != tt.master {
+			t.Errorf("#%d: wrong master: got: %q, want: %q", i, outCV.Master, tt.master)
+		}
+		if len(outCV.Failovers) != tt.failovers {
+			t.Errorf("#%d: wrong number of failovers: got: %d, want: %d", i, len(outCV.Failovers), tt.failovers)
+		}
+		if outCV.FailoverRefusedReason != tt.refusedReason {
+			t.Errorf("#%d: wrong failover refused reason: got: %q, want: %q", i, outCV.FailoverRefusedReason, tt.refusedReason)
+		}
+	}
+}
+
+func TestIsKeeperHealthy(t *testing.T) {
+	now := time.Now()
+	cfg := cluster.NewDefaultConfig()
+	cfg.KeeperFailInterval = 20 * time.Second
+	cfg.KeeperFailChecks = 3
+	cfg.KeeperRecoveryInterval = time.Minute
+
+	tests := []struct {
+		keeperState *cluster.KeeperState
+		healthy     bool
+	}{
+		// Healthy and responding
+		{
+			keeperState: &cluster.KeeperState{Healthy: true},
+			healthy:     true,
+		},
+		// Failing since less than KeeperFailInterval
+		{
+			keeperState: &cluster.KeeperState{Healthy: true, ErrorStartTime: now.Add(-10 * time.Second), FailedChecks: 5},
+			healthy:     true,
+		},
+		// Failing since more than KeeperFailInterval but not enough failed checks
+		{
+			keeperState: &cluster.KeeperState{Healthy: true, ErrorStartTime: now.Add(-30 * time.Second), FailedChecks: 2},
+			healthy:     true,
+		},
+		{
+			keeperState: &cluster.KeeperState{Healthy: true, ErrorStartTime: now.Add(-30 * time.Second), FailedChecks: 3},
+			healthy:     false,
+		},
+		// Unhealthy and still failing
+		{
+			keeperState: &cluster.KeeperState{Healthy: false, ErrorStartTime: now.Add(-1 * time.Second), FailedChecks: 1},
+			healthy:     false,
+		},
+		// Unhealthy and responding since less than KeeperRecoveryInterval
+		{
+			keeperState: &cluster.KeeperState{Healthy: false, RecoveryStartTime: now.Add(-30 * time.Second)},
+			healthy:     false,
+		},
+		// Unhealthy and responding since more than KeeperRecoveryInterval
+		{
+			keeperState: &cluster.KeeperState{Healthy: false, RecoveryStartTime: now.Add(-2 * time.Minute)},
+			healthy:     true,
+		},
+	}
+
+	d := NewDecider(cfg)
+	for i, tt := range tests {
+		if healthy := d.isKeeperHealthy(tt.keeperState); healthy != tt.healthy {
+			t.Errorf("#%d: got healthy: %t, want: %t", i, healthy, tt.healthy)
+		}
+	}
+}
+
+func TestUpdateKeepersStateRecovery(t *testing.T) {
+	cfg := cluster.NewDefaultConfig()
+	cfg.KeeperRecoveryInterval = time.Minute
+	d := NewDecider(cfg)
+
+	cv := &cluster.ClusterView{Version: 1, Master: "01", KeepersRole: cluster.KeepersRole{}}
+	keepersInfo := cluster.KeepersInfo{
+		"01": &cluster.KeeperInfo{ID: "01"},
+		"02": &cluster.KeeperInfo{ID: "02"},
+	}
+	keepersPGState := map[string]*cluster.PostgresState{
+		"01": &cluster.PostgresState{},
+		"02": &cluster.PostgresState{},
+	}
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{ID: "01", Healthy: true},
+	}
+
+	// A new keeper must be responding for the recovery interval before
+	// being healthy
+	newKeepersState := d.UpdateKeepersState(cv, keepersState, keepersInfo, keepersPGState)
+	if !newKeepersState["01"].Healthy {
+		t.Errorf("keeper 01 should be healthy")
+	}
+	k, ok := newKeepersState["02"]
+	if !ok {
+		t.Fatalf("recovering keeper 02 removed")
+	}
+	if k.Healthy {
+		t.Errorf("keeper 02 shouldn't be healthy")
+	}
+	k.RecoveryStartTime = k.RecoveryStartTime.Add(-2 * time.Minute)
+	newKeepersState = d.UpdateKeepersState(cv, newKeepersState, keepersInfo, keepersPGState)
+	if !newKeepersState["02"].Healthy {
+		t.Errorf("keeper 02 should be healthy")
+	}
+
+	// A failed check resets the recovery
+	newKeepersState["02"].Healthy = false
+	delete(keepersPGState, "02")
+	newKeepersState = d.UpdateKeepersState(cv, newKeepersState, keepersInfo, keepersPGState)
+	if _, ok := newKeepersState["02"]; ok {
+		t.Errorf("failing unhealthy keeper 02 not removed")
+	}
+}
+
+func TestUpdateKeepersStateQuarantine(t *testing.T) {
+	d := NewDecider(cluster.NewDefaultConfig())
+
+	cv := &cluster.ClusterView{Version: 1, Master: "01", KeepersRole: cluster.KeepersRole{}}
+	keepersInfo := cluster.KeepersInfo{
+		"01": &cluster.KeeperInfo{ID: "01"},
+		"02": &cluster.KeeperInfo{ID: "02"},
+		"03": &cluster.KeeperInfo{ID: "03"},
+	}
+	keepersPGState := map[string]*cluster.PostgresState{
+		"01": &cluster.PostgresState{Initialized: true, SystemID: "100"},
+		"02": &cluster.PostgresState{Initialized: true, SystemID: "200"},
+		"03": &cluster.PostgresState{Initialized: false},
+	}
+
+	newKeepersState := d.UpdateKeepersState(cv, cluster.KeepersState{}, keepersInfo, keepersPGState)
+	if newKeepersState["01"].Quarantined() {
+		t.Errorf("master 01 shouldn't be quarantined")
+	}
+	wantReason := "its system ID (200) is different than the master system ID (100)"
+	if reason := newKeepersState["02"].QuarantineReason; reason != wantReason {
+		t.Errorf("wrong keeper 02 quarantine reason: got: %q, want: %q", reason, wantReason)
+	}
+	if newKeepersState["03"].Quarantined() {
+		t.Errorf("uninitialized keeper 03 shouldn't be quarantined")
+	}
+
+	// Quarantined keepers are never elected or used as synchronous standbys
+	newKeepersState["02"].ClusterViewVersion = 1
+	if err := d.CheckStandby(cv, newKeepersState, "02", "01"); err == nil || err.Error() != "it's quarantined: "+wantReason {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// Released after a resync from the master
+	keepersPGState["02"] = &cluster.PostgresState{Initialized: true, SystemID: "100"}
+	newKeepersState = d.UpdateKeepersState(cv, newKeepersState, keepersInfo, keepersPGState)
+	if newKeepersState["02"].Quarantined() {
+		t.Errorf("keeper 02 should be released from quarantine")
+	}
+}
+
+func TestUpdateKeepersStateEvents(t *testing.T) {
+	d := NewDecider(cluster.NewDefaultConfig())
+
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+			"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+		},
+	}
+	// 03 is failing since more than KeeperFailInterval and now stops
+	// responding
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{ID: "01", Healthy: true, PGState: &cluster.PostgresState{}},
+		"02": &cluster.KeeperState{ID: "02", Healthy: true, PGState: &cluster.PostgresState{}},
+		"03": &cluster.KeeperState{ID: "03", Healthy: true, ErrorStartTime: time.Now().Add(-time.Hour), FailedChecks: 100, PGState: &cluster.PostgresState{}},
+	}
+	keepersInfo := cluster.KeepersInfo{
+		"01": &cluster.KeeperInfo{ID: "01"},
+		"02": &cluster.KeeperInfo{ID: "02"},
+	}
+	keepersPGState := map[string]*cluster.PostgresState{
+		"01": &cluster.PostgresState{},
+		"02": &cluster.PostgresState{},
+	}
+
+	newKeepersState := d.UpdateKeepersState(cv, keepersState, keepersInfo, keepersPGState)
+	if _, ok := newKeepersState["03"]; ok {
+		t.Fatalf("unhealthy keeper 03 should be removed")
+	}
+	events := cluster.NewEvents(keepersState, cv, newKeepersState, cv)
+	got := []string{}
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s", e.Type, e.KeeperID))
+	}
+	want := []string{"KeeperUnhealthy 03", "KeeperRemoved 03"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong events: got: %v, want: %v", got, want)
+	}
+}
+
+func TestSwitchoverClusterView(t *testing.T) {
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+			"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+		},
+		ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+	}
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{
+			ID:                 "01",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 100},
+		},
+		"02": &cluster.KeeperState{
+			ID:                 "02",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 90},
+		},
+		"03": &cluster.KeeperState{
+			ID:                 "03",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 95},
+		},
+	}
+
+	tests := []struct {
+		keepersState cluster.KeepersState
+		target       string
+		outCV        *cluster.ClusterView
+		err          error
+	}{
+		// No target provided: best standby elected, old master follows it
+		{
+			keepersState: keepersState,
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "03",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: "03"},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: ""},
+				},
+			},
+		},
+		// Provided target elected
+		{
+			keepersState: keepersState,
+			target:       "02",
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "02",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: "02"},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+				},
+			},
+		},
+		{
+			keepersState: keepersState,
+			target:       "01",
+			err:          fmt.Errorf(`cannot switchover to keeper "01" since it's the current master`),
+		},
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100 + cluster.DefaultMaxReplicationLagB},
+				},
+			},
+			target: "02",
+			err:    fmt.Errorf(`cannot switchover to keeper "02" since its replication lag in bytes (262144) more than maximum possible lag (262144)`),
+		},
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            false,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+			},
+			err: fmt.Errorf(`master "01" is not healthy or not converged`),
+		},
+	}
+
+	for i, tt := range tests {
+		d := NewDecider(cluster.NewDefaultConfig())
+		outCV, err := d.SwitchoverClusterView(cv, tt.keepersState, tt.target)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !outCV.Equals(tt.outCV) {
+			t.Errorf(spew.Sprintf("#%d: wrong outCV: got: %#v, want: %#v", i, outCV, tt.outCV))
+		}
+	}
+}
+
+func TestRemoveKeeper(t *testing.T) {
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+			"03": &cluster.KeeperRole{ID: "03", Follow: "02"},
+		},
+		ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+	}
+	keepersState := cluster.KeepersState{
+		"01": &cluster.KeeperState{
+			ID:                 "01",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 100},
+		},
+		"02": &cluster.KeeperState{
+			ID:                 "02",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 90},
+		},
+		"03": &cluster.KeeperState{
+			ID:                 "03",
+			ClusterViewVersion: 1,
+			Healthy:            true,
+			PGState:            &cluster.PostgresState{XLogPos: 95},
+		},
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		cfg                 *cluster.Config
+		synchronousStandbys []string
+		failovers           []time.Time
+		id                  string
+		force               bool
+		outCV               *cluster.ClusterView
+		outFailovers        int
+		err                 error
+	}{
+		// Standby removed, its follower moved to the master
+		{
+			id: "02",
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+				},
+				ProxyConf: &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+		},
+		// Synchronous standby removed, replaced by another standby
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02"},
+			id:                  "02",
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"03"},
+				ProxyConf:           &cluster.ProxyConf{Host: "01", Port: "01"},
+			},
+		},
+		// Master removal without force
+		{
+			id:  "01",
+			err: fmt.Errorf(`keeper "01" is the current master`),
+		},
+		// Forced master removal: best standby elected and failover recorded
+		{
+			failovers: []time.Time{now.Add(-2 * time.Hour), now.Add(-30 * time.Minute)},
+			id:        "01",
+			force:     true,
+			outCV: &cluster.ClusterView{
+				Version: 2,
+				Master:  "03",
+				KeepersRole: cluster.KeepersRole{
+					"02": &cluster.KeeperRole{ID: "02", Follow: "03"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: ""},
+				},
+			},
+			outFailovers: 2,
+		},
+		// Forced master removal refused in maintenance mode
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.MaintenanceMode = true
+				return cfg
+			}(),
+			id:    "01",
+			force: true,
+			err:   fmt.Errorf(`keeper "01" is the current master and the cluster is in maintenance mode`),
+		},
+		// Forced master removal refused exceeding the max failovers
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.MaxFailovers = 1
+				cfg.FailoverWindow = time.Hour
+				return cfg
+			}(),
+			failovers: []time.Time{now.Add(-30 * time.Minute)},
+			id:        "01",
+			force:     true,
+			err:       fmt.Errorf("cannot elect a new master: max failovers (1) in the last 1h0m0s reached, the next failover is allowed after %s", now.Add(30*time.Minute).Format(time.RFC3339)),
+		},
+		{
+			id:  "04",
+			err: fmt.Errorf(`keeper "04" doesn't exist`),
+		},
+	}
+
+	for i, tt := range tests {
+		cfg := tt.cfg
+		if cfg == nil {
+			cfg = cluster.NewDefaultConfig()
+		}
+		d := NewDecider(cfg)
+		cv := cv.Copy()
+		cv.SynchronousStandbys = tt.synchronousStandbys
+		cv.Failovers = tt.failovers
+		outCV, outKeepersState, err := d.RemoveKeeper(cv, keepersState, tt.id, tt.force)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !outCV.Equals(tt.outCV) {
+			t.Errorf(spew.Sprintf("#%d: wrong outCV: got: %#v, want: %#v", i, outCV, tt.outCV))
+		}
+		if len(outCV.Failovers) != tt.outFailovers {
+			t.Errorf("#%d: wrong number of failovers: got: %d, want: %d", i, len(outCV.Failovers), tt.outFailovers)
+		}
+		if _, ok := outKeepersState[tt.id]; ok {
+			t.Errorf("#%d: keeper %q still in keepers state", i, tt.id)
+		}
+		if _, ok := keepersState[tt.id]; !ok {
+			t.Errorf("#%d: keeper %q removed from the input keepers state", i, tt.id)
+		}
+	}
+}
+
+func TestCheckStandby(t *testing.T) {
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+		},
+	}
+
+	tests := []struct {
+		keepersState cluster.KeepersState
+		err          error
+	}{
+		// Standby lagging in bytes
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 1000000},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+			},
+			err: fmt.Errorf("its replication lag in bytes (999900) more than maximum possible lag (262144)"),
+		},
+		// The xlog position flushed by the standby as reported by the master
+		// is more recent than the one reported by the standby
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 1000000,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 1000000, ReplayLocation: 999000, ReplayLag: 1000},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+			},
+		},
+		// The replication lag in seconds is the one reported by the standby
+		// when the master doesn't report the replay lag time
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 100, ReplayLocation: 100, ReplayLag: 0},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100, ReplicationLag: 3600},
+				},
+			},
+			err: fmt.Errorf("its replication lag (3600) more than maximum possible lag (600)"),
+		},
+		// Idle master: the replay lag time reported by the master is preferred
+		// to the one reported by the standby
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 100, ReplayLocation: 100, ReplayLagTime: cluster.UintP(0)},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100, ReplicationLag: 3600},
+				},
+			},
+		},
+		// The master replication stats of other keepers are ignored
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 1000000,
+						Followers: map[string]*cluster.ReplicationStat{
+							"03": &cluster.ReplicationStat{FlushLocation: 1000000, ReplayLocation: 1000000},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+			},
+			err: fmt.Errorf("its replication lag in bytes (999900) more than maximum possible lag (262144)"),
+		},
+		// Delayed replica without replication lag
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{State: "streaming", FlushLocation: 100, ReplayLocation: 100, ReplayLagTime: cluster.UintP(0)},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                    "02",
+					ClusterViewVersion:    1,
+					Healthy:               true,
+					RecoveryMinApplyDelay: time.Hour,
+					PGState:               &cluster.PostgresState{XLogPos: 100},
+				},
+			},
+			err: fmt.Errorf("keeper is delayed (recovery min apply delay 1h0m0s)"),
+		},
+	}
+
+	for i, tt := range tests {
+		d := NewDecider(cluster.NewDefaultConfig())
+		err := d.CheckStandby(cv, tt.keepersState, "02", cv.Master)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestGetBestStandby(t *testing.T) {
+	cv := &cluster.ClusterView{
+		Version: 1,
+		Master:  "01",
+		KeepersRole: cluster.KeepersRole{
+			"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+			"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+			"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+			"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+		},
+	}
+
+	tests := []struct {
+		cfg                 *cluster.Config
+		synchronousStandbys []string
+		keepersState        cluster.KeepersState
+		bestID              string
+		err                 error
+	}{
+		// Highest xlog position
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "03",
+		},
+		// Highest priority before xlog position
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Priority:           1,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// nofailover keepers ignored
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					NoFailover:         true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					NoFailover:         true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+			},
+			err: fmt.Errorf("no standbys available"),
+		},
+		// delayed replicas ignored
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                    "03",
+					ClusterViewVersion:    1,
+					Healthy:               true,
+					RecoveryMinApplyDelay: time.Hour,
+					PGState:               &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// delayed replicas without replication lag ignored
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{State: "streaming", FlushLocation: 90, ReplayLocation: 90, ReplayLagTime: cluster.UintP(1)},
+							"03": &cluster.ReplicationStat{State: "streaming", FlushLocation: 100, ReplayLocation: 100, ReplayLagTime: cluster.UintP(0)},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                    "03",
+					ClusterViewVersion:    1,
+					Healthy:               true,
+					RecoveryMinApplyDelay: time.Hour,
+					PGState:               &cluster.PostgresState{XLogPos: 100},
+				},
+			},
+			bestID: "02",
+		},
+		// xlog positions flushed by the standbys as reported by the master
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 98, ReplayLocation: 98, ReplayLag: 2},
+							"03": &cluster.ReplicationStat{FlushLocation: 95, ReplayLocation: 95, ReplayLag: 5},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// The replication lag in seconds is the one reported by the standby
+		// when the master doesn't report the replay lag time
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 100, ReplayLocation: 100, ReplayLag: 0},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        100,
+						ReplicationLag: 3600,
+					},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "03",
+		},
+		// Idle master: the standby is elected since the master reports no
+		// replay lag time for it
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 100, ReplayLocation: 100, ReplayLagTime: cluster.UintP(0)},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        100,
+						ReplicationLag: 3600,
+					},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// Zone with other healthy replicas before xlog position
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "b",
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 80},
+				},
+			},
+			bestID: "03",
+		},
+		// Replicas in the same zone but unhealthy
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "b",
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            false,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 80},
+				},
+			},
+			bestID: "02",
+		},
+		// Only synchronous standbys with synchronous replication
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02"},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// first mode: only the synchronous standbys the master waits for
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				cfg.MinSynchronousStandbys = 1
+				cfg.MaxSynchronousStandbys = 2
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02", "03"},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{State: "streaming"},
+							"03": &cluster.ReplicationStat{State: "streaming"},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "02",
+		},
+		// first mode: the master waits for the first streaming standby, the
+		// disconnected 02 is listed before it
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				cfg.MinSynchronousStandbys = 1
+				cfg.MaxSynchronousStandbys = 2
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02", "03"},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"03": &cluster.ReplicationStat{State: "streaming"},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "03",
+		},
+		// first mode: all the listed standbys without the master replication
+		// stats
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				cfg.MinSynchronousStandbys = 1
+				cfg.MaxSynchronousStandbys = 2
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02", "03"},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "03",
+		},
+		// any mode: all the synchronous standbys
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.SynchronousReplication = true
+				cfg.MinSynchronousStandbys = 1
+				cfg.MaxSynchronousStandbys = 2
+				cfg.SynchronousStandbysMode = cluster.SynchronousStandbysModeAny
+				return cfg
+			}(),
+			synchronousStandbys: []string{"02", "03"},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+			},
+			bestID: "03",
+		},
+		// Highest priority before zone
+		{
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "b",
+					Priority:           1,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "c",
+					PGState:            &cluster.PostgresState{XLogPos: 80},
+				},
+			},
+			bestID: "02",
+		},
+		// lag election policy: lowest replication lag before xlog position
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyLag
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        95,
+						ReplicationLag: 5,
+					},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        90,
+						ReplicationLag: 1,
+					},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        80,
+						ReplicationLag: 1,
+					},
+				},
+			},
+			bestID: "03",
+		},
+		// lag election policy: the replay lag in bytes reported by the
+		// master isn't a lag in seconds
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyLag
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 100, ReplayLocation: 100, ReplayLag: 0},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        95,
+						ReplicationLag: 5,
+					},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        90,
+						ReplicationLag: 1,
+					},
+				},
+			},
+			bestID: "03",
+		},
+		// lag election policy: the replay lag time reported by the master is
+		// preferred to the one reported by the standby
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyLag
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos: 100,
+						Followers: map[string]*cluster.ReplicationStat{
+							"02": &cluster.ReplicationStat{FlushLocation: 95, ReplayLocation: 95, ReplayLagTime: cluster.UintP(0)},
+						},
+					},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        95,
+						ReplicationLag: 5,
+					},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState: &cluster.PostgresState{
+						XLogPos:        90,
+						ReplicationLag: 1,
+					},
+				},
+			},
+			bestID: "02",
+		},
+		// zone election policy: previous master's zone before xlog position
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyZone
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "b",
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					Zone:               "a",
+					PGState:            &cluster.PostgresState{XLogPos: 80},
+				},
+			},
+			bestID: "03",
+		},
+		// list election policy: only the candidates, in list order
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyList
+				cfg.ElectionCandidates = []string{"04", "03"}
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+				"04": &cluster.KeeperState{
+					ID:                 "04",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 80},
+				},
+			},
+			bestID: "04",
+		},
+		{
+			cfg: func() *cluster.Config {
+				cfg := cluster.NewDefaultConfig()
+				cfg.ElectionPolicy = cluster.ElectionPolicyList
+				cfg.ElectionCandidates = []string{"04"}
+				return cfg
+			}(),
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{
+					ID:                 "01",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 100},
+				},
+				"02": &cluster.KeeperState{
+					ID:                 "02",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 95},
+				},
+				"03": &cluster.KeeperState{
+					ID:                 "03",
+					ClusterViewVersion: 1,
+					Healthy:            true,
+					PGState:            &cluster.PostgresState{XLogPos: 90},
+				},
+			},
+			err: fmt.Errorf("no standbys available"),
+		},
+	}
+
+	for i, tt := range tests {
+		cfg := tt.cfg
+		if cfg == nil {
+			cfg = cluster.NewDefaultConfig()
+		}
+		d := NewDecider(cfg)
+		cv := cv.Copy()
+		cv.SynchronousStandbys = tt.synchronousStandbys
+		bestID, err := d.GetBestStandby(cv, tt.keepersState, cv.Master)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if bestID != tt.bestID {
+			t.Errorf("#%d: wrong best standby: got: %q, want: %q", i, bestID, tt.bestID)
+		}
+	}
+}
+
+func TestUpdateSynchronousStandbys(t *testing.T) {
+	tests := []struct {
+		config       *cluster.NilConfig
+		prevCV       *cluster.ClusterView
+		keepersState cluster.KeepersState
+		out          []string
+	}{
+		// Synchronous replication disabled
+		{
+			config: &cluster.NilConfig{},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"02"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: nil,
+		},
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: []string{"02", "03"},
+		},
+		// Current synchronous standbys are kept
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"04"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: []string{"02", "04"},
+		},
+		// Unhealthy and nosync keepers are replaced
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"02", "03"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: false},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true, NoSync: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: []string{"04"},
+		},
+		// Not enough valid standbys: previous ones are kept
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(2), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"02", "03"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: false},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: false},
+			},
+			out: []string{"02", "03"},
+		},
+		// Delayed replicas are replaced and never kept
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(2), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"02", "03"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, RecoveryMinApplyDelay: time.Hour},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: false},
+			},
+			out: []string{"03"},
+		},
+		// Delayed replicas without replication lag aren't used
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true, PGState: &cluster.PostgresState{XLogPos: 100}},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, RecoveryMinApplyDelay: time.Hour, PGState: &cluster.PostgresState{XLogPos: 100}},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true, PGState: &cluster.PostgresState{XLogPos: 90, ReplicationLag: 1}},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true, PGState: &cluster.PostgresState{XLogPos: 90, ReplicationLag: 1}},
+			},
+			out: []string{"03", "04"},
+		},
+		// Quarantined keepers aren't used
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+				SynchronousStandbys: []string{"02"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, QuarantineReason: "different system ID"},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: []string{"03", "04"},
+		},
+		// Spare standbys aren't used
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01", Spare: true},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+			},
+			out: []string{"03", "04"},
+		},
+		// Spread across zones starting with the ones different than the
+		// master's one
+		{
+			config: &cluster.NilConfig{SynchronousReplication: cluster.BoolP(true), MinSynchronousStandbys: cluster.UintP(1), MaxSynchronousStandbys: cluster.UintP(2)},
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true, Zone: "a"},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, Zone: "a"},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true, Zone: "b"},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true, Zone: "b"},
+			},
+			out: []string{"03", "02"},
+		},
+	}
+
+	for i, tt := range tests {
+		d := NewDecider(tt.config.ToConfig())
+		cv := tt.prevCV.Copy()
+		d.updateSynchronousStandbys(tt.prevCV, cv, tt.keepersState)
+		if !reflect.DeepEqual(cv.SynchronousStandbys, tt.out) {
+			t.Errorf("#%d: wrong synchronous standbys: got: %v, want: %v", i, cv.SynchronousStandbys, tt.out)
+		}
+	}
+}
+
+func TestUpdateSpareKeepers(t *testing.T) {
+	tests := []struct {
+		desiredStandbys uint
+		prevCV          *cluster.ClusterView
+		keepersState    cluster.KeepersState
+		spares          []string
+	}{
+		// All the keepers replicate by default
+		{
+			desiredStandbys: 0,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01", Spare: true},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01", Spare: true},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+			},
+			spares: []string{},
+		},
+		{
+			desiredStandbys: 2,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+			},
+			spares: []string{"04", "05"},
+		},
+		// Current active standbys are kept, the synchronous ones first
+		{
+			desiredStandbys: 1,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01", Spare: true},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01", Spare: true},
+				},
+				SynchronousStandbys: []string{"04"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+			},
+			spares: []string{"02", "03", "05"},
+		},
+		// A lost standby is replaced by a spare
+		{
+			desiredStandbys: 2,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01", Spare: true},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01", Spare: true},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: false},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+			},
+			spares: []string{"03", "05"},
+		},
+		// Not enough healthy standbys: the current active ones are kept
+		{
+			desiredStandbys: 2,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01", Spare: true},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01", Spare: true},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: false},
+				"04": &cluster.KeeperState{ID: "04", Healthy: false},
+				"05": &cluster.KeeperState{ID: "05", Healthy: false},
+			},
+			spares: []string{"04", "05"},
+		},
+		// Delayed replicas are always active and not counted
+		{
+			desiredStandbys: 1,
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "01"},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, RecoveryMinApplyDelay: time.Hour},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+			},
+			spares: []string{"04", "05"},
+		},
+	}
+
+	for i, tt := range tests {
+		cfg := cluster.NewDefaultConfig()
+		cfg.DesiredStandbys = tt.desiredStandbys
+		d := NewDecider(cfg)
+		cv := tt.prevCV.Copy()
+		d.updateSpareKeepers(tt.prevCV, cv, tt.keepersState)
+		spares := []string{}
+		for _, id := range tt.keepersState.SortedKeys() {
+			if cv.KeepersRole[id].Spare {
+				spares = append(spares, id)
+			}
+		}
+		if !reflect.DeepEqual(spares, tt.spares) {
+			t.Errorf("#%d: wrong spare keepers: got: %v, want: %v", i, spares, tt.spares)
+		}
+	}
+}
+
+func TestUpdateKeepersTree(t *testing.T) {
+	tests := []struct {
+		prevCV       *cluster.ClusterView
+		keepersState cluster.KeepersState
+		follows      map[string]string
+	}{
+		// New keepers
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: ""},
+					"04": &cluster.KeeperRole{ID: "04", Follow: ""},
+					"05": &cluster.KeeperRole{ID: "05", Follow: ""},
+					"06": &cluster.KeeperRole{ID: "06", Follow: ""},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "01", "03": "01", "04": "02", "05": "02", "06": "03"},
+		},
+		// Current followed keepers are kept, new ones follow the nearest
+		// sender
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: ""},
+					"04": &cluster.KeeperRole{ID: "04", Follow: ""},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "01"},
+					"06": &cluster.KeeperRole{ID: "06", Follow: "05"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "01", "03": "05", "04": "02", "05": "01", "06": "05"},
+		},
+		// Followers of an unhealthy standby are moved
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "02"},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "02"},
+					"06": &cluster.KeeperRole{ID: "06", Follow: "03"},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: false},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "01", "03": "01", "04": "03", "05": "06", "06": "03"},
+		},
+		// Synchronous standbys follow the master
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01"},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "01"},
+					"04": &cluster.KeeperRole{ID: "04", Follow: "02"},
+					"05": &cluster.KeeperRole{ID: "05", Follow: "02"},
+					"06": &cluster.KeeperRole{ID: "06", Follow: "03"},
+				},
+				SynchronousStandbys: []string{"05"},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "01", "03": "05", "04": "02", "05": "01", "06": "05"},
+		},
+		// Delayed replicas aren't used as senders
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: ""},
+					"03": &cluster.KeeperRole{ID: "03", Follow: ""},
+					"04": &cluster.KeeperRole{ID: "04", Follow: ""},
+					"05": &cluster.KeeperRole{ID: "05", Follow: ""},
+					"06": &cluster.KeeperRole{ID: "06", Follow: ""},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true, RecoveryMinApplyDelay: time.Hour},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "01", "03": "01", "04": "03", "05": "03", "06": "04"},
+		},
+		// Spare standbys are placed after the active ones and aren't used
+		// as senders
+		{
+			prevCV: &cluster.ClusterView{
+				Version: 1,
+				Master:  "01",
+				KeepersRole: cluster.KeepersRole{
+					"01": &cluster.KeeperRole{ID: "01", Follow: ""},
+					"02": &cluster.KeeperRole{ID: "02", Follow: "01", Spare: true},
+					"03": &cluster.KeeperRole{ID: "03", Follow: "", Spare: true},
+					"04": &cluster.KeeperRole{ID: "04", Follow: ""},
+					"05": &cluster.KeeperRole{ID: "05", Follow: ""},
+					"06": &cluster.KeeperRole{ID: "06", Follow: ""},
+				},
+			},
+			keepersState: cluster.KeepersState{
+				"01": &cluster.KeeperState{ID: "01", Healthy: true},
+				"02": &cluster.KeeperState{ID: "02", Healthy: true},
+				"03": &cluster.KeeperState{ID: "03", Healthy: true},
+				"04": &cluster.KeeperState{ID: "04", Healthy: true},
+				"05": &cluster.KeeperState{ID: "05", Healthy: true},
+				"06": &cluster.KeeperState{ID: "06", Healthy: true},
+			},
+			follows: map[string]string{"01": "", "02": "04", "03": "05", "04": "01", "05": "01", "06": "04"},
+		},
+	}
+
+	for i, tt := range tests {
+		cfg := cluster.NewDefaultConfig()
+		cfg.MaxStandbysPerSender = 2
+		d := NewDecider(cfg)
+		cv := tt.prevCV.Copy()
+		d.updateKeepersTree(tt.prevCV, cv, tt.keepersState)
+		follows := map[string]string{}
+		for id, kr := range cv.KeepersRole {
+			follows[id] = kr.Follow
+		}
+		if !reflect.DeepEqual(follows, tt.follows) {
+			t.Errorf("#%d: wrong keepers tree: got: %v, want: %v", i, follows, tt.follows)
+		}
+	}
+}
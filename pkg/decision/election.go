@@ -0,0 +1,186 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package decision
+
+import (
+	"fmt"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+)
+
+// ElectionPolicy chooses the new master between the standbys that can be
+// elected (healthy, converged, not lagging too much etc...). The keepers
+// priority is always considered before the policy.
+type ElectionPolicy interface {
+	// CheckCandidate returns an error describing why the policy doesn't
+	// allow the keeper with the provided id to replace master.
+	CheckCandidate(keepersState cluster.KeepersState, id string, master string) error
+	// Better reports if the keeper with id a should be preferred to the
+	// keeper with id b to replace master.
+	Better(keepersState cluster.KeepersState, a string, b string, master string) bool
+}
+
+// NewElectionPolicy returns the election policy defined in the cluster
+// config.
+func NewElectionPolicy(cfg *cluster.Config) ElectionPolicy {
+	switch cfg.ElectionPolicy {
+	case cluster.ElectionPolicyLag:
+		return lagPolicy{}
+	case cluster.ElectionPolicyZone:
+		return zonePolicy{}
+	case cluster.ElectionPolicyList:
+		return listPolicy{candidates: cfg.ElectionCandidates}
+	default:
+		return xlogPosPolicy{}
+	}
+}
+
+// xlogPosPolicy prefers the standbys in a zone that still has healthy
+// replicas and then the one with the highest xlog position.
+type xlogPosPolicy struct{}
+
+func (p xlogPosPolicy) CheckCandidate(keepersState cluster.KeepersState, id string, master string) error {
+	return nil
+}
+
+func (p xlogPosPolicy) Better(keepersState cluster.KeepersState, a string, b string, master string) bool {
+	aHasReplicas := zoneHasReplicas(keepersState, a, master)
+	bHasReplicas := zoneHasReplicas(keepersState, b, master)
+	if aHasReplicas != bHasReplicas {
+		return aHasReplicas
+	}
+	return higherXLogPos(keepersState, a, b, master)
+}
+
+// lagPolicy prefers the standby with the lowest replication lag in seconds
+// and then the one with the highest xlog position.
+type lagPolicy struct{}
+
+func (p lagPolicy) CheckCandidate(keepersState cluster.KeepersState, id string, master string) error {
+	return nil
+}
+
+func (p lagPolicy) Better(keepersState cluster.KeepersState, a string, b string, master string) bool {
+	aLag := standbyReplicationLag(keepersState, a, master)
+	bLag := standbyReplicationLag(keepersState, b, master)
+	if aLag != bLag {
+		return aLag < bLag
+	}
+	return higherXLogPos(keepersState, a, b, master)
+}
+
+// zonePolicy prefers the standbys in the same zone of the previous master
+// and then the one with the highest xlog position.
+type zonePolicy struct{}
+
+func (p zonePolicy) CheckCandidate(keepersState cluster.KeepersState, id string, master string) error {
+	return nil
+}
+
+func (p zonePolicy) Better(keepersState cluster.KeepersState, a string, b string, master string) bool {
+	var masterZone string
+	if m, ok := keepersState[master]; ok {
+		masterZone = m.Zone
+	}
+	if masterZone != "" {
+		aInZone := keepersState[a].Zone == masterZone
+		bInZone := keepersState[b].Zone == masterZone
+		if aInZone != bInZone {
+			return aInZone
+		}
+	}
+	return higherXLogPos(keepersState, a, b, master)
+}
+
+// listPolicy elects only the keepers in candidates, preferring the first
+// ones in the list.
+type listPolicy struct {
+	candidates []string
+}
+
+func (p listPolicy) CheckCandidate(keepersState cluster.KeepersState, id string, master string) error {
+	if p.index(id) < 0 {
+		return fmt.Errorf("it's not in the election candidates")
+	}
+	return nil
+}
+
+func (p listPolicy) Better(keepersState cluster.KeepersState, a string, b string, master string) bool {
+	return p.index(a) < p.index(b)
+}
+
+func (p listPolicy) index(id string) int {
+	for i, c := range p.candidates {
+		if c == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// zoneHasReplicas reports if the zone of the keeper with the provided id has
+// other healthy keepers (excluding the master) that can replicate from it.
+func zoneHasReplicas(keepersState cluster.KeepersState, id string, master string) bool {
+	zone := keepersState[id].Zone
+	if zone == "" {
+		return false
+	}
+	for kid, k := range keepersState {
+		if kid == id || kid == master {
+			continue
+		}
+		if k.Healthy && k.Zone == zone {
+			return true
+		}
+	}
+	return false
+}
+
+func higherXLogPos(keepersState cluster.KeepersState, a string, b string, master string) bool {
+	return standbyXLogPos(keepersState, a, master) > standbyXLogPos(keepersState, b, master)
+}
+
+// masterReplicationStat returns the replication state of the keeper with the
+// provided id as last reported by the master (nil if not available).
+func masterReplicationStat(keepersState cluster.KeepersState, id string, master string) *cluster.ReplicationStat {
+	m, ok := keepersState[master]
+	if !ok || m.PGState == nil {
+		return nil
+	}
+	return m.PGState.Followers[id]
+}
+
+// standbyXLogPos returns the xlog position of the standby with the provided
+// id: the highest between the one reported by the standby and the flushed one
+// reported by the master.
+func standbyXLogPos(keepersState cluster.KeepersState, id string, master string) uint64 {
+	xLogPos := keepersState[id].PGState.XLogPos
+	if rs := masterReplicationStat(keepersState, id, master); rs != nil && rs.FlushLocation > xLogPos {
+		xLogPos = rs.FlushLocation
+	}
+	return xLogPos
+}
+
+// standbyReplicationLag returns the replication lag in seconds of the standby
+// with the provided id: the replay lag reported by the master or, when the
+// master doesn't report it (postgres < 10), the one reported by the standby.
+// The standby reported lag is the time since the last replayed transaction so
+// it grows also when the master is idle.
+func standbyReplicationLag(keepersState cluster.KeepersState, id string, master string) uint {
+	if rs := masterReplicationStat(keepersState, id, master); rs != nil && rs.ReplayLagTime != nil {
+		return *rs.ReplayLagTime
+	}
+	return keepersState[id].PGState.ReplicationLag
+}
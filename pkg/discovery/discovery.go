@@ -0,0 +1,81 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery discovers the keepers without using the store: through
+// DNS SRV records or from a static list of addresses.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+)
+
+// SRVLookup returns the SRV records of name
+type SRVLookup func(name string) ([]*net.SRV, error)
+
+func lookupSRV(name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	return addrs, err
+}
+
+// DNS returns the keepers defined by the SRV records of name (for example
+// _stolon-keeper._tcp.service.consul).
+func DNS(name string) (cluster.KeepersDiscoveryInfo, error) {
+	return dns(name, lookupSRV)
+}
+
+func dns(name string, lookup SRVLookup) (cluster.KeepersDiscoveryInfo, error) {
+	addrs, err := lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup SRV records for %q: %v", name, err)
+	}
+	ksdi := cluster.KeepersDiscoveryInfo{}
+	for _, addr := range addrs {
+		ksdi = append(ksdi, &cluster.KeeperDiscoveryInfo{
+			ListenAddress: strings.TrimSuffix(addr.Target, "."),
+			Port:          strconv.Itoa(int(addr.Port)),
+		})
+	}
+	return ksdi, nil
+}
+
+// Static returns the keepers defined by addresses. Every address is a host
+// with an optional port, defaultPort is used when it isn't provided.
+func Static(addresses []string, defaultPort string) (cluster.KeepersDiscoveryInfo, error) {
+	ksdi := cluster.KeepersDiscoveryInfo{}
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			// No port provided
+			host = strings.Trim(address, "[]")
+			port = defaultPort
+		}
+		if host == "" {
+			return nil, fmt.Errorf("wrong keeper address %q: empty host", address)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("wrong keeper address %q: invalid port %q", address, port)
+		}
+		ksdi = append(ksdi, &cluster.KeeperDiscoveryInfo{ListenAddress: host, Port: port})
+	}
+	return ksdi, nil
+}
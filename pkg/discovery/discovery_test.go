@@ -0,0 +1,128 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/gravitational/stolon/pkg/cluster"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestDNS(t *testing.T) {
+	records := map[string][]*net.SRV{
+		"_stolon-keeper._tcp.service.consul": []*net.SRV{
+			&net.SRV{Target: "keeper0.node.consul.", Port: 5431},
+			&net.SRV{Target: "keeper1.node.consul.", Port: 5432},
+		},
+	}
+	lookup := func(name string) ([]*net.SRV, error) {
+		addrs, ok := records[name]
+		if !ok {
+			return nil, fmt.Errorf("no such host")
+		}
+		return addrs, nil
+	}
+
+	tests := []struct {
+		name string
+		ksdi cluster.KeepersDiscoveryInfo
+		err  error
+	}{
+		{
+			name: "_stolon-keeper._tcp.service.consul",
+			ksdi: cluster.KeepersDiscoveryInfo{
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "keeper0.node.consul", Port: "5431"},
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "keeper1.node.consul", Port: "5432"},
+			},
+		},
+		{
+			name: "_stolon-keeper._tcp.unknown",
+			err:  fmt.Errorf(`failed to lookup SRV records for "_stolon-keeper._tcp.unknown": no such host`),
+		},
+	}
+
+	for i, tt := range tests {
+		ksdi, err := dns(tt.name, lookup)
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(ksdi, tt.ksdi) {
+			t.Errorf(spew.Sprintf("#%d: wrong keepers discovery info: got: %#v, want: %#v", i, ksdi, tt.ksdi))
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	tests := []struct {
+		addresses []string
+		ksdi      cluster.KeepersDiscoveryInfo
+		err       error
+	}{
+		{
+			addresses: []string{},
+			ksdi:      cluster.KeepersDiscoveryInfo{},
+		},
+		{
+			addresses: []string{"10.0.0.1:6000", "keeper1", " ", "[fd00::1]", "[fd00::2]:6000"},
+			ksdi: cluster.KeepersDiscoveryInfo{
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "10.0.0.1", Port: "6000"},
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "keeper1", Port: "5431"},
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "fd00::1", Port: "5431"},
+				&cluster.KeeperDiscoveryInfo{ListenAddress: "fd00::2", Port: "6000"},
+			},
+		},
+		{
+			addresses: []string{":6000"},
+			err:       fmt.Errorf(`wrong keeper address ":6000": empty host`),
+		},
+		{
+			addresses: []string{"keeper1:port"},
+			err:       fmt.Errorf(`wrong keeper address "keeper1:port": invalid port "port"`),
+		},
+	}
+
+	for i, tt := range tests {
+		ksdi, err := Static(tt.addresses, "5431")
+		if tt.err != nil {
+			if err == nil {
+				t.Errorf("#%d: got no error, wanted error: %v", i, tt.err)
+			} else if tt.err.Error() != err.Error() {
+				t.Errorf("#%d: got error: %v, wanted error: %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(ksdi, tt.ksdi) {
+			t.Errorf(spew.Sprintf("#%d: wrong keepers discovery info: got: %#v, want: %#v", i, ksdi, tt.ksdi))
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hook runs user provided hooks: HTTP webhooks receiving a json
+// payload and local shell commands.
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+var log = capnslog.NewPackageLogger("github.com/gravitational/stolon/pkg", "hook")
+
+const (
+	DefaultTimeout       = 10 * time.Second
+	DefaultRetries       = 3
+	DefaultRetryInterval = 2 * time.Second
+
+	// max number of notifications waiting to be sent
+	queueSize = 100
+)
+
+// Hook is a notification target.
+type Hook interface {
+	// Run sends the json payload. The env variables (in "key=value"
+	// form) describe the same payload for the hooks that cannot read it.
+	Run(payload []byte, env []string) error
+	String() string
+}
+
+// Webhook posts the payload to URL.
+type Webhook struct {
+	URL     string
+	Timeout time.Duration
+}
+
+func (h *Webhook) Run(payload []byte, env []string) error {
+	client := &http.Client{Timeout: h.Timeout}
+	res, err := client.Post(h.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned non ok code: %s", res.Status)
+	}
+	return nil
+}
+
+func (h *Webhook) String() string {
+	return fmt.Sprintf("webhook %s", h.URL)
+}
+
+// Exec runs the shell command Command with the payload on its standard
+// input.
+type Exec struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (h *Exec) Run(payload []byte, env []string) error {
+	out, err := RunCommand(h.Command, env, payload, h.Timeout)
+	if len(out) > 0 {
+		log.Infof("%s output: %s", h, bytes.TrimSpace(out))
+	}
+	return err
+}
+
+func (h *Exec) String() string {
+	return fmt.Sprintf("exec %s", h.Command)
+}
+
+// RunCommand runs command with "sh -c" (so it can be an executable path
+// followed by its arguments) adding env to the current process environment
+// and writing stdin to its standard input. The command is killed if it
+// doesn't terminate within timeout (if not zero). It returns the command
+// combined output.
+func RunCommand(command string, env []string, stdin []byte, timeout time.Duration) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Run the command in its own process group to also kill its children on
+	// timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-timeoutCh:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return out.Bytes(), fmt.Errorf("command timed out after %s", timeout)
+	}
+}
+
+type notification struct {
+	payload []byte
+	env     []string
+}
+
+// Notifier sends notifications to the hooks in background, one at a time and
+// in order, retrying every failed hook up to retries times.
+type Notifier struct {
+	hooks         []Hook
+	retries       int
+	retryInterval time.Duration
+	queue         chan notification
+}
+
+func NewNotifier(hooks []Hook, retries int, retryInterval time.Duration) *Notifier {
+	n := &Notifier{
+		hooks:         hooks,
+		retries:       retries,
+		retryInterval: retryInterval,
+		queue:         make(chan notification, queueSize),
+	}
+	go n.loop()
+	return n
+}
+
+// Notify queues a notification without blocking. If too many notifications
+// are waiting the new one is dropped.
+func (n *Notifier) Notify(payload []byte, env []string) {
+	if len(n.hooks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- notification{payload: payload, env: env}:
+	default:
+		log.Errorf("too many pending notifications, dropping notification: %s", payload)
+	}
+}
+
+func (n *Notifier) loop() {
+	for nt := range n.queue {
+		for _, h := range n.hooks {
+			n.run(h, nt)
+		}
+	}
+}
+
+func (n *Notifier) run(h Hook, nt notification) {
+	for attempt := 0; ; attempt++ {
+		err := h.Run(nt.payload, nt.env)
+		if err == nil {
+			log.Debugf("%s succeeded", h)
+			return
+		}
+		if attempt >= n.retries {
+			log.Errorf("%s failed, giving up: %v", h, err)
+			return
+		}
+		log.Warningf("%s failed, retrying in %s: %v", h, n.retryInterval, err)
+		time.Sleep(n.retryInterval)
+	}
+}
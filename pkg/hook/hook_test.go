@@ -0,0 +1,133 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		got = string(data)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	h := &Webhook{URL: ts.URL + "/ok", Timeout: time.Second}
+	if err := h.Run([]byte(`{"a":1}`), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != `{"a":1}` {
+		t.Errorf("wrong payload: got: %q, want: %q", got, `{"a":1}`)
+	}
+
+	h = &Webhook{URL: ts.URL + "/fail", Timeout: time.Second}
+	if err := h.Run([]byte(`{}`), nil); err == nil {
+		t.Errorf("got no error, wanted error")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho $HOOK_VAR\ncat\n"), 0700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := RunCommand(script, []string{"HOOK_VAR=value"}, []byte("payload"), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != "value\npayload" {
+		t.Errorf("wrong output: got: %q, want: %q", out, "value\npayload")
+	}
+
+	// The command is run by the shell with its arguments and env
+	args := filepath.Join(dir, "args.sh")
+	if err := ioutil.WriteFile(args, []byte("#!/bin/sh\necho \"$1|$2|$HOOK_VAR\"\n"), 0700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err = RunCommand(args+` first "second arg"`, []string{"HOOK_VAR=value"}, nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != "first|second arg|value\n" {
+		t.Errorf("wrong output: got: %q, want: %q", out, "first|second arg|value\n")
+	}
+	h := &Exec{Command: args + " $HOOK_VAR", Timeout: time.Second}
+	if err := h.Run(nil, []string{"HOOK_VAR=value"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	h = &Exec{Command: args + " && exit 3", Timeout: time.Second}
+	if err := h.Run(nil, nil); err == nil {
+		t.Errorf("got no error, wanted error")
+	}
+
+	slow := filepath.Join(dir, "slow.sh")
+	if err := ioutil.WriteFile(slow, []byte("#!/bin/sh\nsleep 10\n"), 0700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	if _, err := RunCommand(slow, nil, nil, 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error: %v, wanted timeout error", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("command not killed after timeout")
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	var count int32
+	calls := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	n := NewNotifier([]Hook{&Webhook{URL: ts.URL, Timeout: time.Second}}, 5, time.Millisecond)
+	n.Notify([]byte(`{}`), nil)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d calls, got %d", 3, i)
+		}
+	}
+	select {
+	case <-calls:
+		t.Errorf("unexpected call after a successful one")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
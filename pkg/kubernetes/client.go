@@ -0,0 +1,296 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+)
+
+// Config defines how to connect to the kubernetes API server
+type Config struct {
+	// Host is the API server url (https://host:port)
+	Host string
+	// Token is the bearer token used to authenticate
+	Token string
+	// CAData is the PEM encoded CA bundle used to verify the API server
+	// certificate
+	CAData []byte
+}
+
+// InClusterConfig returns the config to connect to the API server from a
+// pod, using its service account token and CA bundle.
+func InClusterConfig() (*Config, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be defined")
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account token: %v", err)
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account ca certificate: %v", err)
+	}
+	return &Config{
+		Host:   "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		CAData: ca,
+	}, nil
+}
+
+// Client is a minimal kubernetes API client. The kubernetes client packages
+// aren't used since they import tons of other packages.
+type Client struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+// NewClient returns a client for the provided config. The API server
+// certificate is verified only against the config CA bundle.
+func NewClient(cfg *Config) (*Client, error) {
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(cfg.CAData); !ok {
+		return nil, fmt.Errorf("failed to parse kubernetes ca certificate")
+	}
+	tr := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+	return &Client{
+		host:   strings.TrimSuffix(cfg.Host, "/"),
+		token:  cfg.Token,
+		client: &http.Client{Transport: tr},
+	}, nil
+}
+
+// PodSelector selects the pods in Namespace matching the LabelSelector and
+// FieldSelector (both optional)
+type PodSelector struct {
+	Namespace     string
+	LabelSelector string
+	FieldSelector string
+}
+
+type ObjectMeta struct {
+	Name              string  `json:"name"`
+	Namespace         string  `json:"namespace"`
+	ResourceVersion   string  `json:"resourceVersion"`
+	DeletionTimestamp *string `json:"deletionTimestamp,omitempty"`
+}
+
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type PodCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type PodStatus struct {
+	Phase      string         `json:"phase"`
+	PodIP      string         `json:"podIP"`
+	Conditions []PodCondition `json:"conditions"`
+}
+
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Status   PodStatus  `json:"status"`
+}
+
+type PodList struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+// Ready reports whether the pod is running, ready (as reported by its
+// readiness probes) and not being deleted.
+func (p *Pod) Ready() bool {
+	if p.Metadata.DeletionTimestamp != nil {
+		return false
+	}
+	if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// Status is returned by the API server on errors
+type Status struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
+
+// APIError is an error returned by the API server
+type APIError struct {
+	Status
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("kubernetes api error: %d %s: %s", e.Code, e.Reason, e.Message)
+}
+
+// IsGone reports whether err is an API server error telling that the
+// requested resource version is too old.
+func IsGone(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && e.Code == http.StatusGone
+}
+
+// WatchEvent types
+const (
+	Added    = "ADDED"
+	Modified = "MODIFIED"
+	Deleted  = "DELETED"
+	Error    = "ERROR"
+)
+
+// PodEvent is a pod change received from a watch
+type PodEvent struct {
+	Type string
+	Pod  Pod
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ListPods returns the pods matching the selector.
+func (c *Client) ListPods(ctx context.Context, sel PodSelector) (*PodList, error) {
+	res, err := c.get(ctx, sel, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var pods PodList
+	if err := json.NewDecoder(res.Body).Decode(&pods); err != nil {
+		return nil, fmt.Errorf("failed to decode pod list: %v", err)
+	}
+	return &pods, nil
+}
+
+// WatchPods watches the changes to the pods matching the selector starting
+// after resourceVersion. The events are sent to the events channel until
+// the watch is closed by the API server, the context is canceled or an error
+// occurs.
+func (c *Client) WatchPods(ctx context.Context, sel PodSelector, resourceVersion string, events chan<- PodEvent) error {
+	q := url.Values{}
+	q.Set("watch", "true")
+	q.Set("resourceVersion", resourceVersion)
+	res, err := c.get(ctx, sel, q)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var e watchEvent
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			return fmt.Errorf("failed to decode watch event: %v", err)
+		}
+		switch e.Type {
+		case Added, Modified, Deleted:
+			var pod Pod
+			if err := json.Unmarshal(e.Object, &pod); err != nil {
+				return fmt.Errorf("failed to decode pod: %v", err)
+			}
+			select {
+			case events <- PodEvent{Type: e.Type, Pod: pod}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case Error:
+			var status Status
+			if err := json.Unmarshal(e.Object, &status); err != nil {
+				return fmt.Errorf("failed to decode watch error: %v", err)
+			}
+			return &APIError{status}
+		default:
+			return fmt.Errorf("unknown watch event type %q", e.Type)
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, sel PodSelector, q url.Values) (*http.Response, error) {
+	if sel.LabelSelector != "" {
+		q.Set("labelSelector", sel.LabelSelector)
+	}
+	if sel.FieldSelector != "" {
+		q.Set("fieldSelector", sel.FieldSelector)
+	}
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?%s", c.host, url.QueryEscape(sel.Namespace), q.Encode())
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		status := Status{Code: res.StatusCode, Reason: res.Status}
+		data, _ := ioutil.ReadAll(res.Body)
+		if err := json.Unmarshal(data, &status); err != nil {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		if status.Code == 0 {
+			status.Code = res.StatusCode
+		}
+		return nil, &APIError{status}
+	}
+	return res, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const testToken = "secret-token"
+
+func newPod(name, ip string, ready bool, rv string) Pod {
+	status := "False"
+	if ready {
+		status = "True"
+	}
+	return Pod{
+		Metadata: ObjectMeta{Name: name, Namespace: "default", ResourceVersion: rv},
+		Status: PodStatus{
+			Phase:      "Running",
+			PodIP:      ip,
+			Conditions: []PodCondition{{Type: "Ready", Status: status}},
+		},
+	}
+}
+
+// fakeAPIServer serves the pods list and watch. The watch sends the queued
+// events and then keeps the connection open until the client goes away.
+type fakeAPIServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	pods     []Pod
+	events   chan PodEvent
+	requests []*http.Request
+}
+
+func newFakeAPIServer(pods []Pod) *fakeAPIServer {
+	s := &fakeAPIServer{pods: pods, events: make(chan PodEvent, 10)}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *fakeAPIServer) handle(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	pods := s.pods
+	s.mutex.Unlock()
+
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Status{Code: http.StatusUnauthorized, Reason: "Unauthorized", Message: "Unauthorized"})
+		return
+	}
+	if req.URL.Path != "/api/v1/namespaces/default/pods" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.URL.Query().Get("watch") != "true" {
+		json.NewEncoder(w).Encode(PodList{Metadata: ListMeta{ResourceVersion: "10"}, Items: pods})
+		return
+	}
+	flusher := w.(http.Flusher)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case e := <-s.events:
+			object, _ := json.Marshal(e.Pod)
+			encoder.Encode(watchEvent{Type: e.Type, Object: object})
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *fakeAPIServer) config() *Config {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.TLS.Certificates[0].Certificate[0]})
+	return &Config{Host: s.URL, Token: testToken, CAData: ca}
+}
+
+// newCACertificate returns a new self signed PEM encoded CA certificate
+func newCACertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestListPods(t *testing.T) {
+	s := newFakeAPIServer([]Pod{newPod("keeper0", "10.0.0.1", true, "1")})
+	defer s.Close()
+
+	c, err := NewClient(s.config())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sel := PodSelector{Namespace: "default", LabelSelector: "app=stolon,component=keeper", FieldSelector: "spec.nodeName=node1"}
+	pods, err := c.ListPods(context.Background(), sel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "keeper0" || pods.Metadata.ResourceVersion != "10" {
+		t.Errorf("wrong pod list: %#v", pods)
+	}
+	q := s.requests[0].URL.Query()
+	if q.Get("labelSelector") != sel.LabelSelector || q.Get("fieldSelector") != sel.FieldSelector {
+		t.Errorf("wrong selectors in query: %v", q)
+	}
+
+	// Wrong token
+	cfg := s.config()
+	cfg.Token = "wrong"
+	c, err = NewClient(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.ListPods(context.Background(), sel); err == nil {
+		t.Errorf("got no error with a wrong token")
+	} else if e, ok := err.(*APIError); !ok || e.Code != http.StatusUnauthorized {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	// API server certificate not signed by the provided CA
+	cfg = s.config()
+	cfg.CAData = newCACertificate(t)
+	c, err = NewClient(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.ListPods(context.Background(), sel); err == nil {
+		t.Errorf("got no error with a wrong CA")
+	}
+
+	if _, err := NewClient(&Config{Host: s.URL, CAData: []byte("not a certificate")}); err == nil {
+		t.Errorf("got no error with a wrong CA certificate")
+	}
+}
+
+func TestPodReady(t *testing.T) {
+	deleted := newPod("keeper0", "10.0.0.1", true, "1")
+	ts := "2016-01-01T00:00:00Z"
+	deleted.Metadata.DeletionTimestamp = &ts
+	pending := newPod("keeper0", "10.0.0.1", true, "1")
+	pending.Status.Phase = "Pending"
+
+	tests := []struct {
+		pod   Pod
+		ready bool
+	}{
+		{pod: newPod("keeper0", "10.0.0.1", true, "1"), ready: true},
+		{pod: newPod("keeper0", "10.0.0.1", false, "1"), ready: false},
+		{pod: newPod("keeper0", "", true, "1"), ready: false},
+		{pod: deleted, ready: false},
+		{pod: pending, ready: false},
+	}
+	for i, tt := range tests {
+		if ready := tt.pod.Ready(); ready != tt.ready {
+			t.Errorf("#%d: got ready: %t, want: %t", i, ready, tt.ready)
+		}
+	}
+}
+
+func TestPodWatcher(t *testing.T) {
+	s := newFakeAPIServer([]Pod{
+		newPod("keeper0", "10.0.0.1", true, "1"),
+		newPod("keeper1", "10.0.0.2", false, "2"),
+	})
+	defer s.Close()
+
+	c, err := NewClient(s.config())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := NewPodWatcher(c, PodSelector{Namespace: "default", LabelSelector: "app=stolon"})
+	if _, err := w.ReadyPodsIPs(); err == nil {
+		t.Errorf("got no error before the pods are synced")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	waitIPs := func(want []string) {
+		var ips []string
+		for i := 0; i < 100; i++ {
+			ips, _ = w.ReadyPodsIPs()
+			if reflect.DeepEqual(ips, want) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("wrong ready pods ips: got: %v, want: %v", ips, want)
+	}
+
+	waitIPs([]string{"10.0.0.1"})
+
+	s.events <- PodEvent{Type: Modified, Pod: newPod("keeper1", "10.0.0.2", true, "11")}
+	s.events <- PodEvent{Type: Added, Pod: newPod("keeper2", "10.0.0.3", true, "12")}
+	waitIPs([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+
+	s.events <- PodEvent{Type: Deleted, Pod: newPod("keeper0", "10.0.0.1", true, "13")}
+	waitIPs([]string{"10.0.0.2", "10.0.0.3"})
+
+	s.mutex.Lock()
+	watchRequest := s.requests[len(s.requests)-1]
+	s.mutex.Unlock()
+	if rv := watchRequest.URL.Query().Get("resourceVersion"); rv != "10" {
+		t.Errorf("wrong watch resource version: %s", rv)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pod watcher not stopped")
+	}
+}
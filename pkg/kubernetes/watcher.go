@@ -0,0 +1,146 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+	"golang.org/x/net/context"
+)
+
+var log = capnslog.NewPackageLogger("github.com/gravitational/stolon/pkg", "kubernetes")
+
+// DefaultRetryInterval is the time waited before listing the pods again
+// after an error
+const DefaultRetryInterval = 5 * time.Second
+
+// PodWatcher keeps an updated view of the pods matching a selector: it
+// lists them and then watches for their changes, listing them again when
+// the watch ends.
+type PodWatcher struct {
+	client        *Client
+	sel           PodSelector
+	retryInterval time.Duration
+
+	mutex  sync.Mutex
+	pods   map[string]Pod
+	synced bool
+}
+
+func NewPodWatcher(client *Client, sel PodSelector) *PodWatcher {
+	return &PodWatcher{
+		client:        client,
+		sel:           sel,
+		retryInterval: DefaultRetryInterval,
+		pods:          map[string]Pod{},
+	}
+}
+
+// Run updates the pods until the context is canceled.
+func (w *PodWatcher) Run(ctx context.Context) {
+	for {
+		err := w.listAndWatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if IsGone(err) {
+			// The watched resource version is too old, list the pods again
+			log.Debugf("pods watch expired, listing pods again")
+			continue
+		}
+		log.Errorf("error watching pods: %v", err)
+		w.mutex.Lock()
+		w.synced = false
+		w.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.retryInterval):
+		}
+	}
+}
+
+func (w *PodWatcher) listAndWatch(ctx context.Context) error {
+	list, err := w.client.ListPods(ctx, w.sel)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	w.pods = map[string]Pod{}
+	for _, pod := range list.Items {
+		w.pods[pod.Metadata.Name] = pod
+	}
+	w.synced = true
+	w.mutex.Unlock()
+
+	resourceVersion := list.Metadata.ResourceVersion
+	for {
+		events := make(chan PodEvent)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- w.client.WatchPods(ctx, w.sel, resourceVersion, events)
+		}()
+	watch:
+		for {
+			select {
+			case e := <-events:
+				w.update(e)
+				resourceVersion = e.Pod.Metadata.ResourceVersion
+			case err := <-errCh:
+				if err != nil {
+					return err
+				}
+				break watch
+			}
+		}
+		// The API server closes the watch after a timeout, start a new
+		// one from the last seen resource version
+		log.Debugf("pods watch closed, restarting it")
+	}
+}
+
+func (w *PodWatcher) update(e PodEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	log.Debugf("pod %q %s", e.Pod.Metadata.Name, e.Type)
+	switch e.Type {
+	case Added, Modified:
+		w.pods[e.Pod.Metadata.Name] = e.Pod
+	case Deleted:
+		delete(w.pods, e.Pod.Metadata.Name)
+	}
+}
+
+// ReadyPodsIPs returns the sorted IPs of the ready pods. It returns an error
+// if the pods aren't known (not yet listed or watch error).
+func (w *PodWatcher) ReadyPodsIPs() ([]string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.synced {
+		return nil, fmt.Errorf("pods not synced with the kubernetes api server")
+	}
+	ips := []string{}
+	for _, pod := range w.pods {
+		if pod.Ready() {
+			ips = append(ips, pod.Status.PodIP)
+		}
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
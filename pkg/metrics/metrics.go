@@ -0,0 +1,234 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements simple gauges and counters exposed using the
+// prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4"
+
+type metricType string
+
+const (
+	counterType metricType = "counter"
+	gaugeType   metricType = "gauge"
+)
+
+// Registry contains a set of metrics and writes them in the prometheus text
+// format.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metric struct {
+	name       string
+	help       string
+	mtype      metricType
+	labelNames []string
+
+	mutex   sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) newMetric(name, help string, mtype metricType, labelNames []string) *metric {
+	m := &metric{
+		name:       name,
+		help:       help,
+		mtype:      mtype,
+		labelNames: labelNames,
+		samples:    map[string]*sample{},
+	}
+	// Metrics without labels are always reported
+	if len(labelNames) == 0 {
+		m.samples[""] = &sample{}
+	}
+	r.mutex.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mutex.Unlock()
+	return m
+}
+
+func (m *metric) getSample(labelValues []string) *sample {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %q: wrong number of label values: got %d, want %d", m.name, len(labelValues), len(m.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string{}, labelValues...)}
+		m.samples[key] = s
+	}
+	return s
+}
+
+func (m *metric) reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.samples = map[string]*sample{}
+	if len(m.labelNames) == 0 {
+		m.samples[""] = &sample{}
+	}
+}
+
+// Gauge is a metric whose value can go up and down.
+type Gauge struct {
+	m *metric
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{m: r.newMetric(name, help, gaugeType, labelNames)}
+}
+
+// Set sets the value of the gauge with the provided label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mutex.Lock()
+	defer g.m.mutex.Unlock()
+	g.m.getSample(labelValues).value = v
+}
+
+// Reset removes all the gauge values. Useful to stop reporting the values of
+// labels that don't exist anymore.
+func (g *Gauge) Reset() {
+	g.m.reset()
+}
+
+// Counter is a metric whose value can only increase.
+type Counter struct {
+	m *metric
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{m: r.newMetric(name, help, counterType, labelNames)}
+}
+
+// Inc increments by 1 the counter with the provided label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the provided label values. v must be
+// positive.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metric %q: counter cannot decrease", c.m.name))
+	}
+	c.m.mutex.Lock()
+	defer c.m.mutex.Unlock()
+	c.m.getSample(labelValues).value += v
+}
+
+// Write writes all the metrics in the prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]*metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if err := r.Write(w); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *metric) write(buf *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.mtype)
+
+	keys := []string{}
+	for k := range m.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.samples[k]
+		buf.WriteString(m.name)
+		if len(m.labelNames) > 0 {
+			buf.WriteString("{")
+			for i, name := range m.labelNames {
+				if i > 0 {
+					buf.WriteString(",")
+				}
+				fmt.Fprintf(buf, "%s=\"%s\"", name, escapeLabelValue(s.labelValues[i]))
+			}
+			buf.WriteString("}")
+		}
+		fmt.Fprintf(buf, " %s\n", formatValue(s.value))
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// BoolToFloat returns 1 if b is true, otherwise 0.
+func BoolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	leader := r.NewGauge("test_leader", "Set to 1 if leader")
+	healthy := r.NewGauge("test_keeper_healthy", "Keeper health\nwith newline", "keeper")
+	failovers := r.NewCounter("test_failovers_total", "Number of failovers")
+	errors := r.NewCounter("test_errors_total", "Number of errors", "op", "kind")
+	lag := r.NewGauge("test_lag", "Lag")
+
+	leader.Set(1)
+	healthy.Set(1, "02")
+	healthy.Set(0, "01")
+	healthy.Set(1, `a"b\c`)
+	failovers.Inc()
+	failovers.Add(2)
+	errors.Inc("get", "timeout")
+	lag.Set(math.Inf(1))
+
+	out := `# HELP test_leader Set to 1 if leader
+# TYPE test_leader gauge
+test_leader 1
+# HELP test_keeper_healthy Keeper health\nwith newline
+# TYPE test_keeper_healthy gauge
+test_keeper_healthy{keeper="01"} 0
+test_keeper_healthy{keeper="02"} 1
+test_keeper_healthy{keeper="a\"b\\c"} 1
+# HELP test_failovers_total Number of failovers
+# TYPE test_failovers_total counter
+test_failovers_total 3
+# HELP test_errors_total Number of errors
+# TYPE test_errors_total counter
+test_errors_total{op="get",kind="timeout"} 1
+# HELP test_lag Lag
+# TYPE test_lag gauge
+test_lag +Inf
+`
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != out {
+		t.Errorf("wrong output: got:\n%s\nwant:\n%s", buf.String(), out)
+	}
+
+	healthy.Reset()
+	leader.Reset()
+	buf.Reset()
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out = `# HELP test_leader Set to 1 if leader
+# TYPE test_leader gauge
+test_leader 0
+# HELP test_keeper_healthy Keeper health\nwith newline
+# TYPE test_keeper_healthy gauge
+# HELP test_failovers_total Number of failovers
+# TYPE test_failovers_total counter
+test_failovers_total 3
+# HELP test_errors_total Number of errors
+# TYPE test_errors_total counter
+test_errors_total{op="get",kind="timeout"} 1
+# HELP test_lag Lag
+# TYPE test_lag gauge
+test_lag +Inf
+`
+	if buf.String() != out {
+		t.Errorf("wrong output after reset: got:\n%s\nwant:\n%s", buf.String(), out)
+	}
+}
@@ -58,6 +58,10 @@ type Manager struct {
 	replUsername    string
 	replPassword    string
 	requestTimeout  time.Duration
+	// WAL apply delay written in recovery.conf (delayed replica)
+	recoveryMinApplyDelay time.Duration
+	// Don't use a replication slot on the followed instance (spare keeper)
+	noReplSlot bool
 }
 
 type Parameters map[string]string
@@ -108,6 +112,18 @@ func (p *Manager) GetParameters() Parameters {
 	return p.parameters
 }
 
+// SetUseReplSlot sets if the recovery.conf written by WriteRecoveryConf uses
+// a replication slot (named as the manager) on the followed instance.
+func (p *Manager) SetUseReplSlot(use bool) {
+	p.noReplSlot = !use
+}
+
+// SetRecoveryMinApplyDelay sets the recovery_min_apply_delay written by
+// WriteRecoveryConf. A zero delay disables it.
+func (p *Manager) SetRecoveryMinApplyDelay(delay time.Duration) {
+	p.recoveryMinApplyDelay = delay
+}
+
 func (p *Manager) Init() error {
 	name := filepath.Join(p.pgBinPath, "initdb")
 	out, err := exec.Command(name, "-D", p.dataDir, "-U", p.suUsername).CombinedOutput()
@@ -167,6 +183,40 @@ out:
 	return nil
 }
 
+// InitStandby initializes the instance with a base backup of a primary
+// external to the cluster. Since the primary can be a non stolon instance it
+// also sets up the configuration files layout used by the keeper.
+func (p *Manager) InitStandby(primaryConnParams ConnParams) error {
+	err := p.initStandby(primaryConnParams)
+	// On every error remove the dataDir, so we don't end with an half initialized database
+	if err != nil {
+		os.RemoveAll(p.dataDir)
+		return err
+	}
+	return nil
+}
+
+func (p *Manager) initStandby(primaryConnParams ConnParams) error {
+	if err := p.SyncFromFollowed(primaryConnParams); err != nil {
+		return fmt.Errorf("error taking base backup from primary: %v", err)
+	}
+	baseConfPath := filepath.Join(p.dataDir, "postgresql-base.conf")
+	if _, err := os.Stat(baseConfPath); os.IsNotExist(err) {
+		if err := os.Rename(filepath.Join(p.dataDir, "postgresql.conf"), baseConfPath); err != nil {
+			return fmt.Errorf("error moving postgresql.conf file to postgresql-base.conf: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(p.dataDir, "conf.d"), 0700); err != nil {
+		return fmt.Errorf("error creating conf.d inside dataDir: %v", err)
+	}
+
+	log.Infof("Setting required accesses to pg_hba.conf")
+	if err := p.writePgHba(); err != nil {
+		return fmt.Errorf("error setting requires accesses to pg_hba.conf: %v", err)
+	}
+	return nil
+}
+
 // Start starts the PostgreSQL server
 func (p *Manager) Start() error {
 	log.Info("Starting database")
@@ -385,6 +435,54 @@ func (p *Manager) GetPrimaryConninfo() (ConnParams, error) {
 	return nil, nil
 }
 
+// GetRecoveryMinApplyDelay returns the recovery_min_apply_delay defined in
+// recovery.conf (0 if not defined).
+func (p *Manager) GetRecoveryMinApplyDelay() (time.Duration, error) {
+	v, err := p.getRecoveryConfParameter("recovery_min_apply_delay")
+	if err != nil || v == "" {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(strings.TrimSuffix(v, "ms"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong recovery_min_apply_delay %q: %v", v, err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// GetPrimarySlotName returns the primary_slot_name defined in recovery.conf
+// (empty if not defined).
+func (p *Manager) GetPrimarySlotName() (string, error) {
+	return p.getRecoveryConfParameter("primary_slot_name")
+}
+
+// getRecoveryConfParameter returns the value of the parameter name defined in
+// recovery.conf (empty if not defined).
+func (p *Manager) getRecoveryConfParameter(name string) (string, error) {
+	regex, err := regexp.Compile(fmt.Sprintf(`^\s*%s\s*=\s*'(.*)'$`, regexp.QuoteMeta(name)))
+	if err != nil {
+		return "", err
+	}
+	fh, err := os.Open(filepath.Join(p.dataDir, "recovery.conf"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		m := regex.FindStringSubmatch(scanner.Text())
+		if len(m) == 2 {
+			return m[1], nil
+		}
+	}
+	return "", nil
+}
+
 func (p *Manager) HasConnString() (bool, error) {
 	regex, err := regexp.Compile(`primary_conninfo`)
 	if err != nil {
@@ -441,6 +539,20 @@ func (p *Manager) WriteConf() error {
 }
 
 func (p *Manager) WriteRecoveryConf(followedConnParams ConnParams) error {
+	slotName := p.name
+	if p.noReplSlot {
+		slotName = ""
+	}
+	return p.writeRecoveryConf(followedConnParams, slotName, p.recoveryMinApplyDelay)
+}
+
+// WriteRemoteRecoveryConf writes a recovery.conf to follow a primary external
+// to the cluster using the replication slot slotName (none if empty).
+func (p *Manager) WriteRemoteRecoveryConf(primaryConnParams ConnParams, slotName string) error {
+	return p.writeRecoveryConf(primaryConnParams, slotName, 0)
+}
+
+func (p *Manager) writeRecoveryConf(followedConnParams ConnParams, slotName string, applyDelay time.Duration) error {
 	f, err := ioutil.TempFile(p.dataDir, "recovery.conf")
 	if err != nil {
 		return err
@@ -448,8 +560,13 @@ func (p *Manager) WriteRecoveryConf(followedConnParams ConnParams) error {
 	defer f.Close()
 
 	f.WriteString("standby_mode = 'on'\n")
-	f.WriteString(fmt.Sprintf("primary_slot_name = '%s'\n", p.name))
+	if slotName != "" {
+		f.WriteString(fmt.Sprintf("primary_slot_name = '%s'\n", slotName))
+	}
 	f.WriteString("recovery_target_timeline = 'latest'\n")
+	if applyDelay > 0 {
+		f.WriteString(fmt.Sprintf("recovery_min_apply_delay = '%dms'\n", int64(applyDelay/time.Millisecond)))
+	}
 
 	if followedConnParams != nil {
 		f.WriteString(fmt.Sprintf("primary_conninfo = '%s'", followedConnParams.ConnString()))
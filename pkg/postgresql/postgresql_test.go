@@ -0,0 +1,97 @@
+// Copyright 2016 Gravitational, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgresql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteRecoveryConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stolon")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager("keeper0", "", dir, "", Parameters{}, "", "", "", "", "", "", time.Second)
+	if err := os.MkdirAll(m.dataDir, 0700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	connParams := ConnParams{"host": "10.0.0.1", "port": "5432"}
+
+	tests := []struct {
+		delay    time.Duration
+		noSlot   bool
+		slotName string
+	}{
+		{delay: 0, slotName: "keeper0"},
+		{delay: 3 * time.Hour, slotName: "keeper0"},
+		{delay: 1500 * time.Millisecond, slotName: "keeper0"},
+		// spare keeper
+		{delay: 0, noSlot: true, slotName: ""},
+	}
+
+	for i, tt := range tests {
+		m.SetRecoveryMinApplyDelay(tt.delay)
+		m.SetUseReplSlot(!tt.noSlot)
+		if err := m.WriteRecoveryConf(connParams); err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		delay, err := m.GetRecoveryMinApplyDelay()
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if delay != tt.delay {
+			t.Errorf("#%d: wrong recovery_min_apply_delay: got: %s, want: %s", i, delay, tt.delay)
+		}
+		slotName, err := m.GetPrimarySlotName()
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if slotName != tt.slotName {
+			t.Errorf("#%d: wrong primary_slot_name: got: %q, want: %q", i, slotName, tt.slotName)
+		}
+		cp, err := m.GetPrimaryConninfo()
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !cp.Equals(connParams) {
+			t.Errorf("#%d: wrong primary_conninfo: got: %v, want: %v", i, cp, connParams)
+		}
+	}
+
+	// Remote recovery.conf never defines a delay
+	if err := m.WriteRemoteRecoveryConf(connParams, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delay, err := m.GetRecoveryMinApplyDelay(); err != nil || delay != 0 {
+		t.Errorf("wrong remote recovery_min_apply_delay: %s, err: %v", delay, err)
+	}
+
+	// No recovery.conf
+	if err := os.Remove(filepath.Join(m.dataDir, "recovery.conf")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delay, err := m.GetRecoveryMinApplyDelay(); err != nil || delay != 0 {
+		t.Errorf("wrong recovery_min_apply_delay without recovery.conf: %s, err: %v", delay, err)
+	}
+}
@@ -232,6 +232,91 @@ func GetPGMasterLocation(ctx context.Context, connString string) (uint64, error)
 	return 0, fmt.Errorf("no rows returned")
 }
 
+// replicationStatsQuery returns the pg_stat_replication query for the
+// provided server version number. Postgres 10 renamed the xlog functions and
+// the *_location columns to wal and *_lsn and added the *_lag columns (null
+// when the follower has caught up with an idle sender).
+func replicationStatsQuery(serverVersionNum int) string {
+	if serverVersionNum >= 100000 {
+		return `select application_name, state,
+		coalesce(sent_lsn - '0/0', 0),
+		coalesce(write_lsn - '0/0', 0),
+		coalesce(flush_lsn - '0/0', 0),
+		coalesce(replay_lsn - '0/0', 0),
+		coalesce(greatest(pg_current_wal_lsn() - replay_lsn, 0), 0),
+		coalesce(extract(epoch from flush_lag), 0)::bigint,
+		coalesce(extract(epoch from replay_lag), 0)::bigint
+		from pg_stat_replication where state <> 'backup'`
+	}
+	return `select application_name, state,
+		coalesce(sent_location - '0/0000000', 0),
+		coalesce(write_location - '0/0000000', 0),
+		coalesce(flush_location - '0/0000000', 0),
+		coalesce(replay_location - '0/0000000', 0),
+		coalesce(greatest(pg_current_xlog_location() - replay_location, 0), 0),
+		null::bigint,
+		null::bigint
+		from pg_stat_replication where state <> 'backup'`
+}
+
+func getServerVersionNum(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := Query(ctx, db, "select current_setting('server_version_num')::int")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+		return version, nil
+	}
+	return 0, fmt.Errorf("no rows returned")
+}
+
+// GetReplicationStats returns the replication state of the connected
+// followers (excluding base backups) by application name (the keeper id).
+func GetReplicationStats(ctx context.Context, connString string) (map[string]*cluster.ReplicationStat, error) {
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version, err := getServerVersionNum(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := Query(ctx, db, replicationStatsQuery(version))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := map[string]*cluster.ReplicationStat{}
+	for rows.Next() {
+		var name string
+		var rs cluster.ReplicationStat
+		var flushLagTime, replayLagTime sql.NullInt64
+		if err := rows.Scan(&name, &rs.State, &rs.SentLocation, &rs.WriteLocation, &rs.FlushLocation, &rs.ReplayLocation, &rs.ReplayLag, &flushLagTime, &replayLagTime); err != nil {
+			return nil, err
+		}
+		if flushLagTime.Valid {
+			rs.FlushLagTime = cluster.UintP(uint(flushLagTime.Int64))
+		}
+		if replayLagTime.Valid {
+			rs.ReplayLagTime = cluster.UintP(uint(replayLagTime.Int64))
+		}
+		stats[name] = &rs
+	}
+	return stats, rows.Err()
+}
+
+// IntToPGLSN returns the pg_lsn text representation of an xlog position
+func IntToPGLSN(v uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(v>>32), uint32(v))
+}
+
 func PGLSNToInt(lsn string) (uint64, error) {
 	parts := strings.Split(lsn, "/")
 	if len(parts) != 2 {
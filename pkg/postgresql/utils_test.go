@@ -16,6 +16,7 @@ package postgresql
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -89,3 +90,60 @@ func TestValidReplSlotName(t *testing.T) {
 		}
 	}
 }
+
+func TestPGLSN(t *testing.T) {
+	tests := []struct {
+		lsn string
+		v   uint64
+	}{
+		{lsn: "0/0", v: 0},
+		{lsn: "0/5000090", v: 0x5000090},
+		{lsn: "1A/B3000028", v: 0x1AB3000028},
+	}
+	for i, tt := range tests {
+		v, err := PGLSNToInt(tt.lsn)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if v != tt.v {
+			t.Errorf("#%d: wrong xlog position: got: %d, want: %d", i, v, tt.v)
+		}
+		if lsn := IntToPGLSN(tt.v); lsn != tt.lsn {
+			t.Errorf("#%d: wrong pg_lsn: got: %s, want: %s", i, lsn, tt.lsn)
+		}
+	}
+}
+
+func TestReplicationStatsQuery(t *testing.T) {
+	tests := []struct {
+		version int
+		in      []string
+		notIn   []string
+	}{
+		{
+			version: 90600,
+			in:      []string{"sent_location", "write_location", "flush_location", "replay_location", "pg_current_xlog_location()"},
+			notIn:   []string{"_lsn", "pg_current_wal_lsn()", "flush_lag", "replay_lag"},
+		},
+		{
+			version: 100000,
+			in:      []string{"sent_lsn", "write_lsn", "flush_lsn", "replay_lsn", "pg_current_wal_lsn()", "flush_lag", "replay_lag"},
+			notIn:   []string{"_location", "xlog"},
+		},
+	}
+
+	for i, tt := range tests {
+		q := replicationStatsQuery(tt.version)
+		for _, s := range tt.in {
+			if !strings.Contains(q, s) {
+				t.Errorf("#%d: query doesn't contain %q: %s", i, s, q)
+			}
+		}
+		for _, s := range tt.notIn {
+			if strings.Contains(q, s) {
+				t.Errorf("#%d: query contains %q: %s", i, s, q)
+			}
+		}
+	}
+}
@@ -52,6 +52,7 @@ const (
 	leaderSentinelInfoFile  = "/sentinels/leaderinfo"
 	sentinelsInfoDir        = "/sentinels/info/"
 	proxiesInfoDir          = "/proxies/info/"
+	eventsFile              = "events"
 )
 
 const (
@@ -65,6 +66,13 @@ const (
 	minTTL = 20 * time.Second
 )
 
+const (
+	// Max number of events kept in the store
+	maxEvents = 100
+	// Max number of retries of a concurrently modified events list update
+	maxEventsUpdateRetries = 5
+)
+
 type StoreManager struct {
 	clusterPath string
 	store       kvstore.Store
@@ -188,6 +196,60 @@ func (e *StoreManager) GetClusterView() (*cluster.ClusterView, *kvstore.KVPair,
 	return cd.ClusterView, pair, nil
 }
 
+// GetEvents returns the cluster events, from the oldest to the newest.
+func (e *StoreManager) GetEvents() (cluster.Events, error) {
+	events, _, err := e.getEvents()
+	return events, err
+}
+
+func (e *StoreManager) getEvents() (cluster.Events, *kvstore.KVPair, error) {
+	events := cluster.Events{}
+	path := filepath.Join(e.clusterPath, eventsFile)
+	pair, err := e.store.Get(path)
+	if err != nil {
+		if err != kvstore.ErrKeyNotFound {
+			return nil, nil, err
+		}
+		return events, nil, nil
+	}
+	if err := json.Unmarshal(pair.Value, &events); err != nil {
+		return nil, nil, err
+	}
+	return events, pair, nil
+}
+
+// AppendEvents appends the provided events to the cluster events. Only the
+// newest maxEvents are kept.
+func (e *StoreManager) AppendEvents(newEvents cluster.Events) error {
+	if len(newEvents) == 0 {
+		return nil
+	}
+	path := filepath.Join(e.clusterPath, eventsFile)
+	var err error
+	for i := 0; i < maxEventsUpdateRetries; i++ {
+		var events cluster.Events
+		var pair *kvstore.KVPair
+		events, pair, err = e.getEvents()
+		if err != nil {
+			return err
+		}
+		events = append(events, newEvents...)
+		if len(events) > maxEvents {
+			events = events[len(events)-maxEvents:]
+		}
+		var eventsj []byte
+		eventsj, err = json.Marshal(events)
+		if err != nil {
+			return err
+		}
+		_, _, err = e.store.AtomicPut(path, eventsj, pair, nil)
+		if err != kvstore.ErrKeyModified && err != kvstore.ErrKeyExists {
+			return err
+		}
+	}
+	return err
+}
+
 func (e *StoreManager) SetKeeperDiscoveryInfo(id string, ms *cluster.KeeperDiscoveryInfo, ttl time.Duration) error {
 	msj, err := json.Marshal(ms)
 	if err != nil {
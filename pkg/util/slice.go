@@ -22,3 +22,17 @@ func StringInSlice(s []string, e string) bool {
 	}
 	return false
 }
+
+// CompareStringSlice reports if the two slices contain the same elements in
+// the same order. A nil slice is equal to an empty one.
+func CompareStringSlice(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
+	return true
+}